@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Audit actions
+const (
+	AuditCreate     = "create"
+	AuditUpdate     = "update"
+	AuditSoftDelete = "soft_delete"
+	AuditRestore    = "restore"
+	AuditHardDelete = "hard_delete"
+)
+
+// AuditLog records one change made through the API. Rows are only ever
+// added; Before and After hold the entity as JSON around the change.
+type AuditLog struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	ActorID   *uint           `gorm:"index" json:"actor_id"` // nil for guests ordering from a QR code
+	ActorName string          `gorm:"size:100;not null" json:"actor_name"`
+	Action    string          `gorm:"size:20;not null;index" json:"action"`
+	Entity    string          `gorm:"size:50;not null;index:idx_audit_entity" json:"entity"`
+	EntityID  string          `gorm:"size:50;not null;index:idx_audit_entity" json:"entity_id"`
+	Before    json.RawMessage `gorm:"type:json" json:"before"`
+	After     json.RawMessage `gorm:"type:json" json:"after"`
+	RequestID string          `gorm:"size:64;index" json:"request_id"`
+	CreatedAt time.Time       `gorm:"index" json:"created_at"`
+}
+
+var errAuditAppendOnly = errors.New("audit log is append-only")
+
+// BeforeUpdate keeps audit rows from being changed
+func (AuditLog) BeforeUpdate(*gorm.DB) error { return errAuditAppendOnly }
+
+// BeforeDelete keeps audit rows from being removed
+func (AuditLog) BeforeDelete(*gorm.DB) error { return errAuditAppendOnly }
+
+// auditSnapshot captures an entity as JSON, so later changes to it do not
+// show up in the before image
+func auditSnapshot(entity interface{}) json.RawMessage {
+	if entity == nil {
+		return nil
+	}
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// recordAudit adds an audit row for a change made by a request. Inside a
+// transaction, pass the transaction so the row is dropped on rollback. A
+// failure to write the row is logged rather than failing the change.
+func recordAudit(c echo.Context, db *gorm.DB, action, entity string, entityID interface{}, before, after interface{}) {
+	entry := AuditLog{
+		ActorName: "guest",
+		Action:    action,
+		Entity:    entity,
+		EntityID:  fmt.Sprint(entityID),
+		Before:    auditSnapshot(before),
+		After:     auditSnapshot(after),
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	}
+	if staff, ok := currentStaff(c); ok {
+		entry.ActorID = &staff.ID
+		entry.ActorName = staff.Username
+	}
+	if err := db.Create(&entry).Error; err != nil {
+		log.Printf("Failed to write audit log for %s %s %s: %v", action, entity, entry.EntityID, err)
+	}
+}
+
+// GetAuditLogsController lists audit rows, newest first. It filters on
+// actor_id, action, entity, entity_id, request_id and a from/to date range
+// (YYYY-MM-DD, inclusive), and pages with page and limit.
+func GetAuditLogsController(c echo.Context) error {
+	query := DB.Model(&AuditLog{})
+	for _, filter := range []string{"actor_id", "action", "entity", "entity_id", "request_id"} {
+		if value := c.QueryParam(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+	if from := c.QueryParam("from"); from != "" {
+		day, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return createErrorResponse(c, http.StatusBadRequest, "from must be a date like 2024-01-31")
+		}
+		query = query.Where("created_at >= ?", day)
+	}
+	if to := c.QueryParam("to"); to != "" {
+		day, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			return createErrorResponse(c, http.StatusBadRequest, "to must be a date like 2024-01-31")
+		}
+		query = query.Where("created_at < ?", day.AddDate(0, 0, 1))
+	}
+
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve audit log")
+	}
+	var logs []AuditLog
+	if err := query.Order("id DESC").Limit(limit).Offset((page - 1) * limit).Find(&logs).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve audit log")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Audit log retrieved successfully",
+		Data: map[string]interface{}{
+			"total": total,
+			"page":  page,
+			"limit": limit,
+			"logs":  logs,
+		},
+	})
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Staff roles
+const (
+	RoleOwner   = "owner"
+	RoleManager = "manager"
+	RoleCashier = "cashier"
+	RoleWaiter  = "waiter"
+	RoleKitchen = "kitchen"
+)
+
+var staffRoles = map[string]bool{
+	RoleOwner:   true,
+	RoleManager: true,
+	RoleCashier: true,
+	RoleWaiter:  true,
+	RoleKitchen: true,
+}
+
+// Staff is an account that can sign in to the POS. Staff sign in with their
+// password, or with their PIN at a shared terminal.
+type Staff struct {
+	gorm.Model
+	Nama         string `gorm:"size:100;not null" json:"nama"`
+	Username     string `gorm:"size:50;not null;uniqueIndex" json:"username"`
+	PasswordHash string `gorm:"size:100;not null" json:"-"`
+	PINHash      string `gorm:"size:100" json:"-"`
+	Role         string `gorm:"size:10;not null" json:"role"`
+	Active       bool   `gorm:"not null;default:true" json:"active"`
+}
+
+// StaffRequest creates or updates a staff account. Password and PIN are
+// left unchanged on update when empty.
+type StaffRequest struct {
+	Nama     string `json:"nama"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	PIN      string `json:"pin"`
+	Role     string `json:"role"`
+	Active   *bool  `json:"active"`
+}
+
+// LoginRequest signs in with a password, or with a PIN when no password is
+// given
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	PIN      string `json:"pin"`
+}
+
+// staffClaims are the claims of a staff login token
+type staffClaims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+const staffContextKey = "staff"
+
+var (
+	jwtSecretOnce sync.Once
+	jwtSecretKey  []byte
+)
+
+// jwtSecret is the key login tokens are signed with. Without JWT_SECRET a
+// random key is used and everyone is signed out on restart.
+func jwtSecret() []byte {
+	jwtSecretOnce.Do(func() {
+		if secret := os.Getenv("JWT_SECRET"); secret != "" {
+			jwtSecretKey = []byte(secret)
+			return
+		}
+		log.Println("JWT_SECRET is not set; staff will be signed out on restart")
+		jwtSecretKey = make([]byte, 32)
+		if _, err := rand.Read(jwtSecretKey); err != nil {
+			log.Fatalf("Failed to generate JWT secret: %v", err)
+		}
+	})
+	return jwtSecretKey
+}
+
+// tokenTTL is how long a login lasts, from JWT_TTL_HOURS
+func tokenTTL() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("JWT_TTL_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 12
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// issueToken signs a login token for a staff account
+func issueToken(staff Staff) (string, time.Time, error) {
+	expiresAt := time.Now().Add(tokenTTL())
+	claims := staffClaims{
+		Role: staff.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(staff.ID), 10),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret())
+	return token, expiresAt, err
+}
+
+// requireAuth lets requests with a valid login token through and puts the
+// signed-in staff on the context. The account is looked up on every request
+// so deactivating it or changing its role takes effect at once.
+func requireAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		header := c.Request().Header.Get(echo.HeaderAuthorization)
+		raw, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || raw == "" {
+			return createErrorResponse(c, http.StatusUnauthorized, "Please sign in")
+		}
+
+		var claims staffClaims
+		_, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (interface{}, error) {
+			return jwtSecret(), nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+		if err != nil {
+			return createErrorResponse(c, http.StatusUnauthorized, "Your session has expired; please sign in again")
+		}
+
+		var staff Staff
+		if err := DB.First(&staff, "id = ?", claims.Subject).Error; err != nil || !staff.Active {
+			return createErrorResponse(c, http.StatusUnauthorized, "Your account is not active")
+		}
+		c.Set(staffContextKey, staff)
+		return next(c)
+	}
+}
+
+// requireRole only lets the given roles through. Owners and managers may
+// do everything other staff can, so they are always allowed.
+func requireRole(roles ...string) echo.MiddlewareFunc {
+	allowed := map[string]bool{RoleOwner: true, RoleManager: true}
+	for _, role := range roles {
+		allowed[role] = true
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			staff, ok := currentStaff(c)
+			if !ok || !allowed[staff.Role] {
+				return createErrorResponse(c, http.StatusForbidden, "You are not allowed to do this")
+			}
+			return next(c)
+		}
+	}
+}
+
+// currentStaff returns the staff signed in for a request
+func currentStaff(c echo.Context) (Staff, bool) {
+	staff, ok := c.Get(staffContextKey).(Staff)
+	return staff, ok
+}
+
+// hashSecret hashes a password or PIN for storage
+func hashSecret(secret string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// validPIN reports whether a PIN is 4 to 6 digits
+func validPIN(pin string) bool {
+	if len(pin) < 4 || len(pin) > 6 {
+		return false
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// applyStaffRequest validates a staff request and copies it onto an
+// account. Only owners may create or change owners and managers.
+func applyStaffRequest(actor Staff, staff *Staff, request StaffRequest) string {
+	if staff.ID != 0 && (staff.Role == RoleOwner || staff.Role == RoleManager) && actor.Role != RoleOwner && actor.ID != staff.ID {
+		return "Only an owner can change an owner or manager account"
+	}
+
+	if request.Nama = strings.TrimSpace(request.Nama); request.Nama != "" {
+		staff.Nama = request.Nama
+	}
+	if request.Username = strings.ToLower(strings.TrimSpace(request.Username)); request.Username != "" {
+		staff.Username = request.Username
+	}
+	if request.Role != "" && request.Role != staff.Role {
+		if !staffRoles[request.Role] {
+			return "Role must be owner, manager, cashier, waiter or kitchen"
+		}
+		if (request.Role == RoleOwner || request.Role == RoleManager) && actor.Role != RoleOwner {
+			return "Only an owner can grant the " + request.Role + " role"
+		}
+		if actor.ID == staff.ID {
+			return "You cannot change your own role"
+		}
+		staff.Role = request.Role
+	}
+	if request.Active != nil {
+		if actor.ID == staff.ID && !*request.Active {
+			return "You cannot deactivate your own account"
+		}
+		staff.Active = *request.Active
+	}
+
+	if request.Password != "" {
+		if len(request.Password) < 8 {
+			return "Password must be at least 8 characters"
+		}
+		hash, err := hashSecret(request.Password)
+		if err != nil {
+			return "Failed to hash password"
+		}
+		staff.PasswordHash = hash
+	}
+	if request.PIN != "" {
+		if !validPIN(request.PIN) {
+			return "PIN must be 4 to 6 digits"
+		}
+		hash, err := hashSecret(request.PIN)
+		if err != nil {
+			return "Failed to hash PIN"
+		}
+		staff.PINHash = hash
+	}
+
+	if staff.Nama == "" || staff.Username == "" {
+		return "Nama and Username are required"
+	}
+	if staff.PasswordHash == "" {
+		return "Password is required"
+	}
+	if staff.Role == "" {
+		return "Role is required"
+	}
+
+	var count int64
+	DB.Unscoped().Model(&Staff{}).Where("username = ? AND id <> ?", staff.Username, staff.ID).Count(&count)
+	if count > 0 {
+		return "Username " + staff.Username + " is already taken"
+	}
+	return ""
+}
+
+// seedOwner creates the first owner account from OWNER_USERNAME and
+// OWNER_PASSWORD when there are no staff yet
+func seedOwner() {
+	var count int64
+	if err := DB.Model(&Staff{}).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+
+	username, password := os.Getenv("OWNER_USERNAME"), os.Getenv("OWNER_PASSWORD")
+	if username == "" || password == "" {
+		log.Println("No staff accounts yet; set OWNER_USERNAME and OWNER_PASSWORD to create the owner")
+		return
+	}
+	hash, err := hashSecret(password)
+	if err != nil {
+		log.Printf("Failed to create owner account: %v", err)
+		return
+	}
+	owner := Staff{Nama: username, Username: strings.ToLower(username), PasswordHash: hash, Role: RoleOwner, Active: true}
+	if err := DB.Create(&owner).Error; err != nil {
+		log.Printf("Failed to create owner account: %v", err)
+	}
+}
+
+// LoginController signs a staff member in and returns a login token
+func LoginController(c echo.Context) error {
+	var request LoginRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+
+	var staff Staff
+	err := DB.Where("username = ? AND active = ?", strings.ToLower(strings.TrimSpace(request.Username)), true).First(&staff).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to sign in")
+	}
+
+	hash, secret := staff.PasswordHash, request.Password
+	if request.Password == "" {
+		hash, secret = staff.PINHash, request.PIN
+	}
+	if err != nil || hash == "" || secret == "" || bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) != nil {
+		return createErrorResponse(c, http.StatusUnauthorized, "Wrong username, password or PIN")
+	}
+
+	token, expiresAt, err := issueToken(staff)
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to sign in")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Signed in successfully",
+		Data: map[string]interface{}{
+			"token":      token,
+			"expires_at": expiresAt,
+			"staff":      staff,
+		},
+	})
+}
+
+// GetCurrentStaffController returns the signed-in staff member
+func GetCurrentStaffController(c echo.Context) error {
+	staff, _ := currentStaff(c)
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Staff retrieved successfully",
+		Data:    staff,
+	})
+}
+
+// CreateStaffController adds a staff account
+func CreateStaffController(c echo.Context) error {
+	var request StaffRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	actor, _ := currentStaff(c)
+	staff := Staff{Active: true}
+	if request.Role == "" {
+		return createErrorResponse(c, http.StatusBadRequest, "Role is required")
+	}
+	if message := applyStaffRequest(actor, &staff, request); message != "" {
+		return createErrorResponse(c, http.StatusBadRequest, message)
+	}
+
+	if err := DB.Create(&staff).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to create staff")
+	}
+	recordAudit(c, DB, AuditCreate, "staff", staff.ID, nil, staff)
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Staff created successfully",
+		Data:    staff,
+	})
+}
+
+// GetStaffController lists the staff accounts
+func GetStaffController(c echo.Context) error {
+	var staff []Staff
+	if err := DB.Order("nama").Find(&staff).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve staff")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Staff retrieved successfully",
+		Data:    staff,
+	})
+}
+
+// UpdateStaffController changes a staff account, its role, password or PIN
+func UpdateStaffController(c echo.Context) error {
+	var staff Staff
+	if err := DB.First(&staff, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Staff not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve staff")
+	}
+
+	var request StaffRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	actor, _ := currentStaff(c)
+	before := auditSnapshot(staff)
+	if message := applyStaffRequest(actor, &staff, request); message != "" {
+		return createErrorResponse(c, http.StatusBadRequest, message)
+	}
+
+	if err := DB.Save(&staff).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update staff")
+	}
+	recordAudit(c, DB, AuditUpdate, "staff", staff.ID, before, staff)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Staff updated successfully",
+		Data:    staff,
+	})
+}
+
+// DeleteStaffController removes a staff account. The row is soft deleted
+// so the account's name stays on past records.
+func DeleteStaffController(c echo.Context) error {
+	var staff Staff
+	if err := DB.First(&staff, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Staff not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve staff")
+	}
+
+	actor, _ := currentStaff(c)
+	if actor.ID == staff.ID {
+		return createErrorResponse(c, http.StatusBadRequest, "You cannot delete your own account")
+	}
+	if (staff.Role == RoleOwner || staff.Role == RoleManager) && actor.Role != RoleOwner {
+		return createErrorResponse(c, http.StatusForbidden, "Only an owner can delete an owner or manager account")
+	}
+
+	if err := DB.Delete(&staff).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to delete staff")
+	}
+	recordAudit(c, DB, AuditSoftDelete, "staff", staff.ID, staff, nil)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Staff deleted successfully",
+		Data:    nil,
+	})
+}
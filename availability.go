@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// ProductSchedule is a time window in which a product can be ordered, e.g.
+// breakfast items from 06:00 to 11:00. Products without schedules can be
+// ordered all day. DayOfWeek limits the window to one weekday (0 is
+// Sunday); a window whose end is before its start runs past midnight.
+type ProductSchedule struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	ProductID uint   `gorm:"not null;index" json:"product_id"`
+	DayOfWeek *int   `json:"day_of_week"`
+	StartTime string `gorm:"size:5;not null" json:"start_time"` // HH:MM
+	EndTime   string `gorm:"size:5;not null" json:"end_time"`   // HH:MM
+}
+
+// SetProductAvailabilityRequest switches a product on or off the menu
+type SetProductAvailabilityRequest struct {
+	Available bool `json:"available"`
+}
+
+// SetProductSchedulesRequest replaces the time windows of a product
+type SetProductSchedulesRequest struct {
+	Schedules []ProductSchedule `json:"schedules"`
+}
+
+// clockLayout is the format of schedule start and end times
+const clockLayout = "15:04"
+
+// covers reports whether the schedule window includes the given time
+func (s ProductSchedule) covers(now time.Time) bool {
+	start, err := time.Parse(clockLayout, s.StartTime)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse(clockLayout, s.EndTime)
+	if err != nil {
+		return false
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	day := int(now.Weekday())
+
+	if from < to {
+		return (s.DayOfWeek == nil || *s.DayOfWeek == day) && minute >= from && minute < to
+	}
+	// Past midnight: the late part belongs to the scheduled day, the early
+	// part to the day after it
+	if minute >= from {
+		return s.DayOfWeek == nil || *s.DayOfWeek == day
+	}
+	if minute < to {
+		return s.DayOfWeek == nil || (*s.DayOfWeek+1)%7 == day
+	}
+	return false
+}
+
+// label describes the schedule window for error messages
+func (s ProductSchedule) label() string {
+	window := s.StartTime + "-" + s.EndTime
+	if s.DayOfWeek == nil {
+		return window
+	}
+	return time.Weekday(*s.DayOfWeek).String() + " " + window
+}
+
+// productUnavailableReason explains why a product cannot be ordered at the
+// given time, or returns an empty string when it can
+func productUnavailableReason(db *gorm.DB, product Product, now time.Time) (string, error) {
+	if !product.Available {
+		return fmt.Sprintf("%s is currently unavailable", product.Name), nil
+	}
+
+	var schedules []ProductSchedule
+	if err := db.Where("product_id = ?", product.ID).Order("id").Find(&schedules).Error; err != nil {
+		return "", err
+	}
+	if len(schedules) == 0 {
+		return "", nil
+	}
+
+	windows := make([]string, 0, len(schedules))
+	for _, schedule := range schedules {
+		if schedule.covers(now) {
+			return "", nil
+		}
+		windows = append(windows, schedule.label())
+	}
+	return fmt.Sprintf("%s is only available %s", product.Name, strings.Join(windows, ", ")), nil
+}
+
+// availableNow marks which products can be ordered at the given time
+func availableNow(db *gorm.DB, products []Product, now time.Time) error {
+	ids := make([]uint, 0, len(products))
+	for _, product := range products {
+		ids = append(ids, product.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var schedules []ProductSchedule
+	if err := db.Where("product_id IN ?", ids).Find(&schedules).Error; err != nil {
+		return err
+	}
+	scheduled := make(map[uint]bool)
+	open := make(map[uint]bool)
+	for _, schedule := range schedules {
+		scheduled[schedule.ProductID] = true
+		if schedule.covers(now) {
+			open[schedule.ProductID] = true
+		}
+	}
+
+	for i := range products {
+		products[i].AvailableNow = products[i].Available && (!scheduled[products[i].ID] || open[products[i].ID])
+	}
+	return nil
+}
+
+// SetProductAvailabilityController takes a product off the menu or puts it
+// back, without hiding it from reports like a soft delete would
+func SetProductAvailabilityController(c echo.Context) error {
+	var product Product
+	if err := DB.First(&product, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Product not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve product")
+	}
+
+	var request SetProductAvailabilityRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	before := auditSnapshot(product)
+	if err := DB.Model(&product).Update("available", request.Available).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update product availability")
+	}
+	product.Available = request.Available
+	recordAudit(c, DB, AuditUpdate, "product", product.ID, before, product)
+
+	products := []Product{product}
+	if err := availableNow(DB, products, time.Now()); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update product availability")
+	}
+
+	message := "Product is available"
+	if !request.Available {
+		message = "Product is unavailable"
+	}
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: message,
+		Data:    products[0],
+	})
+}
+
+// GetProductSchedulesController lists the time windows of a product
+func GetProductSchedulesController(c echo.Context) error {
+	var schedules []ProductSchedule
+	if err := DB.Where("product_id = ?", c.Param("id")).Order("id").Find(&schedules).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve product schedules")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Product schedules retrieved successfully",
+		Data:    schedules,
+	})
+}
+
+// SetProductSchedulesController replaces the time windows of a product. An
+// empty list makes the product orderable all day.
+func SetProductSchedulesController(c echo.Context) error {
+	var product Product
+	if err := DB.First(&product, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Product not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve product")
+	}
+
+	var request SetProductSchedulesRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	for i := range request.Schedules {
+		schedule := &request.Schedules[i]
+		if _, err := time.Parse(clockLayout, schedule.StartTime); err != nil {
+			return createErrorResponse(c, http.StatusBadRequest, "start_time must be HH:MM")
+		}
+		if _, err := time.Parse(clockLayout, schedule.EndTime); err != nil {
+			return createErrorResponse(c, http.StatusBadRequest, "end_time must be HH:MM")
+		}
+		if schedule.StartTime == schedule.EndTime {
+			return createErrorResponse(c, http.StatusBadRequest, "start_time and end_time cannot be equal")
+		}
+		if schedule.DayOfWeek != nil && (*schedule.DayOfWeek < 0 || *schedule.DayOfWeek > 6) {
+			return createErrorResponse(c, http.StatusBadRequest, "day_of_week must be between 0 (Sunday) and 6 (Saturday)")
+		}
+		schedule.ID = 0
+		schedule.ProductID = product.ID
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var before []ProductSchedule
+		if err := tx.Where("product_id = ?", product.ID).Order("id").Find(&before).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("product_id = ?", product.ID).Delete(&ProductSchedule{}).Error; err != nil {
+			return err
+		}
+		if len(request.Schedules) > 0 {
+			if err := tx.Create(&request.Schedules).Error; err != nil {
+				return err
+			}
+		}
+		recordAudit(c, tx, AuditUpdate, "product_schedules", product.ID, before, request.Schedules)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to save product schedules")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Product schedules saved successfully",
+		Data:    request.Schedules,
+	})
+}
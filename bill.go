@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+func init() {
+	// Money is returned as JSON numbers, e.g. 15000.5 rather than "15000.5"
+	decimal.MarshalJSONWithoutQuotes = true
+}
+
+var hundred = decimal.NewFromInt(100)
+
+// Bill is what a table session owes for its unpaid orders
+type Bill struct {
+	TableNumber  int             `json:"table_number"`
+	SessionID    uint            `json:"session_id"`
+	MergedTables []int           `json:"merged_tables"`
+	Orders       []Order         `json:"orders"`
+	Discounts    []OrderDiscount `json:"discounts"`
+	Payments     []Payment       `json:"payments"`
+	Breakdown    BillBreakdown   `json:"breakdown"`
+	TotalAmount  decimal.Decimal `json:"total_amount"`
+	PaidAmount   decimal.Decimal `json:"paid_amount"`
+	AmountDue    decimal.Decimal `json:"amount_due"`
+	Split        *BillSplit      `json:"split,omitempty"`
+}
+
+// BillBreakdown shows how the grand total of a bill is made up
+type BillBreakdown struct {
+	Subtotal         decimal.Decimal `json:"subtotal"`
+	Discount         decimal.Decimal `json:"discount"`
+	ServiceCharge    decimal.Decimal `json:"service_charge"`
+	Tax              decimal.Decimal `json:"tax"`
+	Rounding         decimal.Decimal `json:"rounding"`
+	GrandTotal       decimal.Decimal `json:"grand_total"`
+	ServicePercent   decimal.Decimal `json:"service_percent"`
+	TaxPercent       decimal.Decimal `json:"tax_percent"`
+	PricesIncludeTax bool            `json:"prices_include_tax"`
+}
+
+// buildBill returns the bill of the open session at a table. A table
+// without an open session has an empty bill.
+func buildBill(db *gorm.DB, tableNumber int) (Bill, error) {
+	session, err := activeSession(db, tableNumber)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		session = TableSession{TableNumber: tableNumber}
+	} else if err != nil {
+		return Bill{TableNumber: tableNumber}, err
+	}
+	return buildSessionBill(db, session, closedOrderStatuses)
+}
+
+// buildSessionBill loads the orders of a session, leaving out those with an
+// excluded status, applies the active promos, service charge and tax, and
+// subtracts the payments already made against those orders
+func buildSessionBill(db *gorm.DB, session TableSession, excluded []string) (Bill, error) {
+	bill := Bill{
+		TableNumber:  session.TableNumber,
+		SessionID:    session.ID,
+		Discounts:    []OrderDiscount{},
+		Payments:     []Payment{},
+		MergedTables: []int{},
+	}
+
+	// Retrieve the orders
+	if session.ID != 0 {
+		merged, err := mergedTables(db, session.ID)
+		if err != nil {
+			return bill, err
+		}
+		bill.MergedTables = append(bill.MergedTables, merged...)
+
+		if err := db.Preload("Items", "voided_at IS NULL").Preload("Items.Product").Preload("Items.Variant").Preload("Items.Modifiers").
+			Where("session_id = ? AND status NOT IN ? AND status NOT IN ? AND deleted_at IS NULL", session.ID, excluded, unbilledOrderStatuses).
+			Find(&bill.Orders).Error; err != nil {
+			return bill, err
+		}
+	}
+
+	promos, err := activePromos(db)
+	if err != nil {
+		return bill, err
+	}
+	setting, err := loadTaxSetting(db)
+	if err != nil {
+		return bill, err
+	}
+
+	// Calculate the subtotal and the bundle discounts of every order
+	subtotal := calculateTotalAmount(bill.Orders)
+	discount := decimal.Zero
+	for _, order := range bill.Orders {
+		for _, orderDiscount := range applyPromos(order, promos) {
+			bill.Discounts = append(bill.Discounts, orderDiscount)
+			discount = discount.Add(orderDiscount.Amount)
+		}
+	}
+	bill.Breakdown = calculateBreakdown(subtotal, discount, setting)
+	bill.TotalAmount = bill.Breakdown.GrandTotal
+
+	if err := loadBillPayments(db, &bill); err != nil {
+		return bill, err
+	}
+	for _, payment := range bill.Payments {
+		bill.PaidAmount = bill.PaidAmount.Add(payment.Amount)
+	}
+	bill.AmountDue = decimal.Max(bill.TotalAmount.Sub(bill.PaidAmount), decimal.Zero)
+
+	if err := loadBillSplit(db, &bill); err != nil {
+		return bill, err
+	}
+
+	return bill, nil
+}
+
+// calculateBreakdown applies service charge, tax and cash rounding to the
+// discounted subtotal. Service is charged on the discounted subtotal. With
+// tax-exclusive prices, tax is added on top of the discounted subtotal plus
+// service; with tax-inclusive prices, the tax is the part of that amount
+// already included and nothing is added.
+func calculateBreakdown(subtotal, discount decimal.Decimal, setting TaxSetting) BillBreakdown {
+	breakdown := BillBreakdown{
+		Subtotal:         roundMoney(subtotal),
+		Discount:         roundMoney(discount),
+		ServicePercent:   setting.ServicePercent,
+		TaxPercent:       setting.TaxPercent,
+		PricesIncludeTax: setting.PricesIncludeTax,
+	}
+
+	net := decimal.Max(breakdown.Subtotal.Sub(breakdown.Discount), decimal.Zero)
+	breakdown.ServiceCharge = roundMoney(net.Mul(setting.ServicePercent).Div(hundred))
+	taxable := net.Add(breakdown.ServiceCharge)
+
+	total := taxable
+	if setting.PricesIncludeTax {
+		breakdown.Tax = roundMoney(taxable.Mul(setting.TaxPercent).Div(hundred.Add(setting.TaxPercent)))
+	} else {
+		breakdown.Tax = roundMoney(taxable.Mul(setting.TaxPercent).Div(hundred))
+		total = total.Add(breakdown.Tax)
+	}
+
+	breakdown.GrandTotal = total
+	if setting.RoundingUnit.IsPositive() {
+		breakdown.GrandTotal = total.Div(setting.RoundingUnit).Round(0).Mul(setting.RoundingUnit)
+	}
+	breakdown.Rounding = breakdown.GrandTotal.Sub(total)
+
+	return breakdown
+}
+
+// billOrderIDs returns the IDs of the orders on a bill
+func billOrderIDs(bill Bill) []uint {
+	ids := make([]uint, 0, len(bill.Orders))
+	for _, order := range bill.Orders {
+		ids = append(ids, order.ID)
+	}
+	return ids
+}
+
+// itemProductPrice is the product price an item was ordered at. Items
+// always carry a snapshot once migrated; the live product is only a fallback.
+func itemProductPrice(item OrderItem) decimal.Decimal {
+	if item.ProductName != "" {
+		return item.UnitPrice
+	}
+	return item.Product.Price
+}
+
+// itemUnitPrice is the product price plus the chosen variant and modifiers,
+// as they were priced when the item was ordered
+func itemUnitPrice(item OrderItem) decimal.Decimal {
+	price := itemProductPrice(item)
+	if item.ProductName != "" {
+		price = price.Add(item.VariantPriceDelta)
+	} else if item.Variant != nil {
+		price = price.Add(item.Variant.PriceDelta)
+	}
+	for _, modifier := range item.Modifiers {
+		price = price.Add(modifier.PriceDelta)
+	}
+	return price
+}
+
+// itemLineTotal is the unit price of an order item times its quantity
+func itemLineTotal(item OrderItem) decimal.Decimal {
+	return itemUnitPrice(item).Mul(decimal.NewFromInt(int64(item.Quantity)))
+}
+
+// roundMoney rounds an amount to whole cents, half away from zero
+func roundMoney(amount decimal.Decimal) decimal.Decimal {
+	return amount.Round(2)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Category groups products on the menu and routes them to kitchen printers
+type Category struct {
+	gorm.Model
+	Nama      string `gorm:"size:255;uniqueIndex" json:"nama"`
+	SortOrder int    `gorm:"not null;default:0" json:"sort_order"`
+}
+
+// ReorderCategoriesRequest lists category IDs in their new menu order
+type ReorderCategoriesRequest struct {
+	IDs []uint `json:"ids"`
+}
+
+// categoryName is the name of a product's category, for logs and messages
+func categoryName(product Product) string {
+	if product.Category != nil {
+		return product.Category.Nama
+	}
+	if product.CategoryID != nil {
+		return fmt.Sprintf("#%d", *product.CategoryID)
+	}
+	return "-"
+}
+
+// validateProductCategory checks that a product points at an existing
+// category
+func validateProductCategory(product Product) string {
+	if product.CategoryID == nil {
+		return "CategoryID is required"
+	}
+	var category Category
+	if err := DB.First(&category, *product.CategoryID).Error; err != nil {
+		return "Category not found"
+	}
+	return ""
+}
+
+// findOrCreateCategory returns the category with the given name, creating
+// it when missing and restoring it when it was soft-deleted
+func findOrCreateCategory(db *gorm.DB, nama string) (Category, error) {
+	var category Category
+	err := db.Unscoped().Where("nama = ?", nama).First(&category).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		category = Category{Nama: nama}
+		return category, db.Create(&category).Error
+	}
+	if err != nil {
+		return category, err
+	}
+	if category.DeletedAt.Valid {
+		if err := db.Unscoped().Model(&category).Update("deleted_at", nil).Error; err != nil {
+			return category, err
+		}
+		category.DeletedAt = gorm.DeletedAt{}
+	}
+	return category, nil
+}
+
+// migrateCategories replaces the free-text category columns of products
+// and printer routes with a category_id pointing at the categories table
+func migrateCategories() {
+	if err := DB.AutoMigrate(&Category{}); err != nil {
+		log.Printf("Failed to migrate categories: %v", err)
+		return
+	}
+	if err := convertCategoryColumn(&Product{}, "products"); err != nil {
+		log.Printf("Failed to migrate product categories: %v", err)
+	}
+	if err := convertCategoryColumn(&PrinterRoute{}, "printer_routes"); err != nil {
+		log.Printf("Failed to migrate printer route categories: %v", err)
+	}
+}
+
+// convertCategoryColumn fills category_id from the old category string of
+// a table and drops the string column once every row is converted. MySQL
+// commits schema changes straight away, so instead of a transaction each
+// step can be re-run: a failed run leaves the old column in place and the
+// next start picks up the rows still missing a category_id.
+func convertCategoryColumn(model interface{}, table string) error {
+	migrator := DB.Migrator()
+	if !migrator.HasTable(table) || !migrator.HasColumn(model, "category") {
+		return nil
+	}
+
+	if !migrator.HasColumn(model, "category_id") {
+		if err := migrator.AddColumn(model, "CategoryID"); err != nil {
+			return err
+		}
+	}
+
+	unconverted := func() *gorm.DB {
+		return DB.Table(table).Where("category_id IS NULL AND TRIM(category) <> ''")
+	}
+	var names []string
+	if err := unconverted().Distinct("category").Pluck("category", &names).Error; err != nil {
+		return err
+	}
+	for _, nama := range names {
+		category, err := findOrCreateCategory(DB, strings.TrimSpace(nama))
+		if err != nil {
+			return err
+		}
+		if err := unconverted().Where("category = ?", nama).Update("category_id", category.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	var remaining int64
+	if err := unconverted().Count(&remaining).Error; err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return fmt.Errorf("%d rows of %s still have no category_id; keeping the category column", remaining, table)
+	}
+
+	return migrator.DropColumn(model, "category")
+}
+
+// CreateCategoryController adds a category
+func CreateCategoryController(c echo.Context) error {
+	var category Category
+	if err := c.Bind(&category); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	category.Nama = strings.TrimSpace(category.Nama)
+	if category.Nama == "" {
+		return createErrorResponse(c, http.StatusBadRequest, "Nama is required")
+	}
+
+	var existingCategory Category
+	if err := DB.Unscoped().Where("nama = ?", category.Nama).First(&existingCategory).Error; err == nil {
+		return createErrorResponse(c, http.StatusConflict, "Category with nama "+category.Nama+" already exists")
+	}
+
+	if err := DB.Create(&category).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to create category")
+	}
+	recordAudit(c, DB, AuditCreate, "category", category.ID, nil, category)
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Category created successfully",
+		Data:    category,
+	})
+}
+
+// GetCategoriesController lists categories in menu order
+func GetCategoriesController(c echo.Context) error {
+	var categories []Category
+	if err := DB.Order("sort_order, nama").Find(&categories).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve categories")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Categories retrieved successfully",
+		Data:    categories,
+	})
+}
+
+// UpdateCategoryController renames a category or changes its sort order
+func UpdateCategoryController(c echo.Context) error {
+	var category Category
+	if err := DB.First(&category, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Category not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve category")
+	}
+
+	var updatedCategory Category
+	if err := c.Bind(&updatedCategory); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+	updatedCategory.Nama = strings.TrimSpace(updatedCategory.Nama)
+	if updatedCategory.Nama == "" {
+		return createErrorResponse(c, http.StatusBadRequest, "Nama is required")
+	}
+
+	var existingCategory Category
+	if err := DB.Unscoped().Where("nama = ? AND id <> ?", updatedCategory.Nama, category.ID).First(&existingCategory).Error; err == nil {
+		return createErrorResponse(c, http.StatusConflict, "Category with nama "+updatedCategory.Nama+" already exists")
+	}
+
+	before := auditSnapshot(category)
+	category.Nama = updatedCategory.Nama
+	category.SortOrder = updatedCategory.SortOrder
+	if err := DB.Save(&category).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update category")
+	}
+	recordAudit(c, DB, AuditUpdate, "category", category.ID, before, category)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Category updated successfully",
+		Data:    category,
+	})
+}
+
+// ReorderCategoriesController sets the sort order of categories to their
+// position in the request
+func ReorderCategoriesController(c echo.Context) error {
+	var request ReorderCategoriesRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	ids := uniqueIDs(request.IDs)
+	if len(ids) == 0 || len(ids) != len(request.IDs) {
+		return createErrorResponse(c, http.StatusBadRequest, "ids must list each category once")
+	}
+
+	var count int64
+	if err := DB.Model(&Category{}).Where("id IN ?", ids).Count(&count).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve categories")
+	}
+	if int(count) != len(ids) {
+		return createErrorResponse(c, http.StatusBadRequest, "Unknown category")
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var before []uint
+		if err := tx.Model(&Category{}).Order("sort_order, nama").Pluck("id", &before).Error; err != nil {
+			return err
+		}
+		for position, id := range ids {
+			if err := tx.Model(&Category{}).Where("id = ?", id).Update("sort_order", position+1).Error; err != nil {
+				return err
+			}
+		}
+		recordAudit(c, tx, AuditUpdate, "category_order", "", before, ids)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to reorder categories")
+	}
+
+	return GetCategoriesController(c)
+}
+
+// SoftDeleteCategoryController soft-deletes a category no product uses
+func SoftDeleteCategoryController(c echo.Context) error {
+	var category Category
+	if err := DB.First(&category, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Category not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find category")
+	}
+
+	var products int64
+	if err := DB.Model(&Product{}).Where("category_id = ?", category.ID).Count(&products).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to check category products")
+	}
+	if products > 0 {
+		return createErrorResponse(c, http.StatusConflict, fmt.Sprintf("Category is still used by %d products", products))
+	}
+
+	if err := DB.Delete(&category).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to delete category")
+	}
+	recordAudit(c, DB, AuditSoftDelete, "category", category.ID, category, nil)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Successfully soft-deleted category",
+		Data:    nil,
+	})
+}
+
+// RestoreCategoryController restores a soft-deleted category
+func RestoreCategoryController(c echo.Context) error {
+	var category Category
+	if err := DB.Unscoped().First(&category, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Category not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find category")
+	}
+
+	if !category.DeletedAt.Valid {
+		return createErrorResponse(c, http.StatusConflict, "Category is not deleted")
+	}
+
+	before := auditSnapshot(category)
+	result := DB.Unscoped().Model(&category).Where("deleted_at IS NOT NULL").Update("deleted_at", nil)
+	if result.Error != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to restore category")
+	}
+	if result.RowsAffected == 0 {
+		return createErrorResponse(c, http.StatusConflict, "Category is not deleted")
+	}
+	category.DeletedAt = gorm.DeletedAt{}
+	recordAudit(c, DB, AuditRestore, "category", category.ID, before, category)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Successfully restored category",
+		Data:    category,
+	})
+}
+
+// DeleteCategoryController permanently deletes a category that no product
+// or printer route refers to, including soft-deleted products
+func DeleteCategoryController(c echo.Context) error {
+	var category Category
+	if err := DB.Unscoped().First(&category, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Category not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find category")
+	}
+
+	var products, routes int64
+	if err := DB.Unscoped().Model(&Product{}).Where("category_id = ?", category.ID).Count(&products).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to check category products")
+	}
+	if err := DB.Model(&PrinterRoute{}).Where("category_id = ?", category.ID).Count(&routes).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to check category printer routes")
+	}
+	if products > 0 || routes > 0 {
+		return createErrorResponse(c, http.StatusConflict, fmt.Sprintf("Category is still used by %d products and %d printer routes", products, routes))
+	}
+
+	if err := DB.Unscoped().Delete(&category).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to delete category")
+	}
+	recordAudit(c, DB, AuditHardDelete, "category", category.ID, category, nil)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Category deleted successfully",
+		Data:    nil,
+	})
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// ESC/POS control sequences
+var (
+	escInit        = []byte{0x1b, 0x40}
+	escAlignLeft   = []byte{0x1b, 0x61, 0x00}
+	escAlignCenter = []byte{0x1b, 0x61, 0x01}
+	escBoldOn      = []byte{0x1b, 0x45, 0x01}
+	escBoldOff     = []byte{0x1b, 0x45, 0x00}
+	escDoubleOn    = []byte{0x1d, 0x21, 0x11}
+	escDoubleOff   = []byte{0x1d, 0x21, 0x00}
+	escCut         = []byte{0x1d, 0x56, 0x42, 0x00}
+)
+
+const (
+	defaultPrinterPort = "9100"
+	printerDialTimeout = 3 * time.Second
+	printerSendTimeout = 10 * time.Second
+)
+
+// escposCodePage describes a printer code page: the number selected with
+// ESC t and the encoder used to turn text into bytes for it
+type escposCodePage struct {
+	number  byte
+	charmap *charmap.Charmap
+}
+
+var escposCodePages = map[string]escposCodePage{
+	"PC437":   {0, charmap.CodePage437},
+	"PC850":   {2, charmap.CodePage850},
+	"PC858":   {19, charmap.CodePage858},
+	"WPC1252": {16, charmap.Windows1252},
+}
+
+// paperColumns returns the characters per line of the default font for a
+// paper width in millimetres
+func paperColumns(paperWidth int) int {
+	if paperWidth == 58 {
+		return 32
+	}
+	return 48
+}
+
+// escposWriter builds an ESC/POS byte stream for one printer
+type escposWriter struct {
+	buf     bytes.Buffer
+	columns int
+	encoder *encoding.Encoder
+}
+
+func newESCPOSWriter(printer Printer) (*escposWriter, error) {
+	codePageName := printer.CodePage
+	if codePageName == "" {
+		codePageName = "PC437"
+	}
+	codePage, ok := escposCodePages[codePageName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported code page %s", codePageName)
+	}
+
+	w := &escposWriter{
+		columns: paperColumns(printer.PaperWidth),
+		encoder: encoding.ReplaceUnsupported(codePage.charmap.NewEncoder()),
+	}
+	w.buf.Write(escInit)
+	w.buf.Write([]byte{0x1b, 0x74, codePage.number})
+	return w, nil
+}
+
+func (w *escposWriter) raw(b []byte) {
+	w.buf.Write(b)
+}
+
+// line writes one line of text in the printer code page
+func (w *escposWriter) line(text string) {
+	encoded, err := w.encoder.String(text)
+	if err != nil {
+		encoded = text
+	}
+	w.buf.WriteString(encoded)
+	w.buf.WriteByte('\n')
+}
+
+// columnsLine writes left aligned and right aligned text on the same line,
+// wrapping the left text when both do not fit
+func (w *escposWriter) columnsLine(left, right string) {
+	space := w.columns - len([]rune(right)) - 1
+	leftRunes := []rune(left)
+	for len(leftRunes) > space && space > 0 {
+		w.line(string(leftRunes[:space]))
+		leftRunes = leftRunes[space:]
+	}
+	padding := w.columns - len(leftRunes) - len([]rune(right))
+	if padding < 1 {
+		padding = 1
+	}
+	w.line(string(leftRunes) + strings.Repeat(" ", padding) + right)
+}
+
+func (w *escposWriter) separator() {
+	w.line(strings.Repeat("-", w.columns))
+}
+
+func (w *escposWriter) cut() {
+	w.buf.WriteString("\n\n\n")
+	w.buf.Write(escCut)
+}
+
+func (w *escposWriter) bytes() []byte {
+	return w.buf.Bytes()
+}
+
+// RenderKitchenTicket renders the items a station has to prepare for an order
+func RenderKitchenTicket(printer Printer, order Order, items []OrderItem) ([]byte, error) {
+	w, err := newESCPOSWriter(printer)
+	if err != nil {
+		return nil, err
+	}
+
+	w.raw(escAlignCenter)
+	w.raw(escBoldOn)
+	w.line(printer.Name)
+	w.raw(escBoldOff)
+	w.raw(escAlignLeft)
+	w.line(fmt.Sprintf("Order #%d", order.ID))
+	w.line(fmt.Sprintf("Meja  %d", order.TableNumber))
+	w.line(order.CreatedAt.Format("02/01/2006 15:04"))
+	w.separator()
+
+	w.raw(escDoubleOn)
+	for _, item := range items {
+		w.line(fmt.Sprintf("%dx %s", item.Quantity, itemLabel(item)))
+		for _, modifier := range item.Modifiers {
+			w.line("   + " + modifier.Name)
+		}
+	}
+	w.raw(escDoubleOff)
+
+	w.cut()
+	return w.bytes(), nil
+}
+
+// RenderVoidTicket renders a cancel ticket telling a station to stop
+// preparing a voided item
+func RenderVoidTicket(printer Printer, order Order, item OrderItem, quantity int, reason string) ([]byte, error) {
+	w, err := newESCPOSWriter(printer)
+	if err != nil {
+		return nil, err
+	}
+
+	w.raw(escAlignCenter)
+	w.raw(escBoldOn)
+	w.raw(escDoubleOn)
+	w.line("*** BATAL ***")
+	w.raw(escDoubleOff)
+	w.line(printer.Name)
+	w.raw(escBoldOff)
+	w.raw(escAlignLeft)
+	w.line(fmt.Sprintf("Order #%d", order.ID))
+	w.line(fmt.Sprintf("Meja  %d", order.TableNumber))
+	w.line(time.Now().Format("02/01/2006 15:04"))
+	w.separator()
+
+	w.raw(escDoubleOn)
+	w.line(fmt.Sprintf("%dx %s", quantity, itemLabel(item)))
+	for _, modifier := range item.Modifiers {
+		w.line("   + " + modifier.Name)
+	}
+	w.raw(escDoubleOff)
+	if reason != "" {
+		w.line("Alasan: " + reason)
+	}
+
+	w.cut()
+	return w.bytes(), nil
+}
+
+// RenderReceipt renders the customer receipt for a bill
+func RenderReceipt(printer Printer, bill Bill) ([]byte, error) {
+	w, err := newESCPOSWriter(printer)
+	if err != nil {
+		return nil, err
+	}
+
+	w.raw(escAlignCenter)
+	w.raw(escBoldOn)
+	w.line("STRUK PEMBAYARAN")
+	w.raw(escBoldOff)
+	w.line(fmt.Sprintf("Meja %d", bill.TableNumber))
+	w.line(time.Now().Format("02/01/2006 15:04"))
+	w.raw(escAlignLeft)
+	w.separator()
+
+	for _, order := range bill.Orders {
+		for _, item := range order.Items {
+			w.columnsLine(
+				fmt.Sprintf("%dx %s", item.Quantity, itemLabel(item)),
+				formatRupiah(itemLineTotal(item)),
+			)
+			for _, modifier := range item.Modifiers {
+				w.line("   + " + modifier.Name)
+			}
+		}
+	}
+	for _, discount := range bill.Discounts {
+		w.columnsLine(fmt.Sprintf("%dx %s", discount.Count, discount.PromoNama), "-"+formatRupiah(discount.Amount))
+	}
+
+	breakdown := bill.Breakdown
+	w.separator()
+	w.columnsLine("Subtotal", formatRupiah(breakdown.Subtotal))
+	if breakdown.Discount.IsPositive() {
+		w.columnsLine("Diskon", "-"+formatRupiah(breakdown.Discount))
+	}
+	if breakdown.ServiceCharge.IsPositive() {
+		w.columnsLine(fmt.Sprintf("Service %s%%", breakdown.ServicePercent), formatRupiah(breakdown.ServiceCharge))
+	}
+	if breakdown.Tax.IsPositive() {
+		label := fmt.Sprintf("PPN %s%%", breakdown.TaxPercent)
+		if breakdown.PricesIncludeTax {
+			label += " (termasuk)"
+		}
+		w.columnsLine(label, formatRupiah(breakdown.Tax))
+	}
+	if !breakdown.Rounding.IsZero() {
+		w.columnsLine("Pembulatan", formatRupiah(breakdown.Rounding))
+	}
+	w.raw(escBoldOn)
+	w.columnsLine("TOTAL", formatRupiah(bill.TotalAmount))
+	w.raw(escBoldOff)
+	w.separator()
+
+	w.raw(escAlignCenter)
+	w.line("Terima kasih")
+	w.cut()
+	return w.bytes(), nil
+}
+
+// RenderTestPage renders a short page to check a printer's settings
+func RenderTestPage(printer Printer) ([]byte, error) {
+	w, err := newESCPOSWriter(printer)
+	if err != nil {
+		return nil, err
+	}
+
+	w.raw(escAlignCenter)
+	w.raw(escBoldOn)
+	w.line(printer.Name)
+	w.raw(escBoldOff)
+	w.raw(escAlignLeft)
+	w.separator()
+	w.columnsLine("Paper", fmt.Sprintf("%dmm", printer.PaperWidth))
+	w.columnsLine("Code page", printer.CodePage)
+	w.columnsLine("Address", printer.Address)
+	w.separator()
+	w.cut()
+	return w.bytes(), nil
+}
+
+// printerAddress adds the raw printing port 9100 to an address without one
+func printerAddress(address string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return net.JoinHostPort(address, defaultPrinterPort)
+	}
+	return address
+}
+
+// SendToPrinter writes raw ESC/POS bytes to a network printer. The address
+// defaults to the raw printing port 9100 when it has no port.
+func SendToPrinter(address string, data []byte) error {
+	if address == "" {
+		return fmt.Errorf("printer has no network address")
+	}
+
+	conn, err := net.DialTimeout("tcp", printerAddress(address), printerDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(printerSendTimeout)); err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+// productLabel is the product name followed by its variant, if any
+func productLabel(product Product) string {
+	if product.Varian == "" {
+		return product.Name
+	}
+	return product.Name + " " + product.Varian
+}
+
+// itemLabel is the product label of an order item followed by the chosen
+// variant, if any, as they were named when the item was ordered
+func itemLabel(item OrderItem) string {
+	label := productLabel(itemProduct(item))
+	if item.ProductName != "" {
+		if item.VariantName != "" {
+			label += " (" + item.VariantName + ")"
+		}
+		return label
+	}
+	if item.Variant != nil {
+		label += " (" + item.Variant.Name + ")"
+	}
+	return label
+}
+
+// formatRupiah formats an amount with dots between thousands, e.g. 15.000
+func formatRupiah(amount decimal.Decimal) string {
+	negative := amount.IsNegative()
+	cents := toCents(amount.Abs())
+	whole := fmt.Sprintf("%d", cents/100)
+
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte('.')
+		}
+		grouped.WriteRune(digit)
+	}
+	if cents%100 != 0 {
+		grouped.WriteString(fmt.Sprintf(",%02d", cents%100))
+	}
+
+	if negative {
+		return "-" + grouped.String()
+	}
+	return grouped.String()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// fakePrinter listens like a raw TCP printer and returns its address and
+// the bytes of the first job it receives
+func fakePrinter(t *testing.T) (string, <-chan []byte) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+	return listener.Addr().String(), received
+}
+
+// printJob sends data to a fake printer and returns what it received
+func printJob(t *testing.T, data []byte) []byte {
+	t.Helper()
+	address, received := fakePrinter(t)
+	if err := SendToPrinter(address, data); err != nil {
+		t.Fatalf("SendToPrinter: %v", err)
+	}
+	select {
+	case got := <-received:
+		return got
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake printer received nothing")
+		return nil
+	}
+}
+
+// printedLines splits a job into its lines
+func printedLines(data []byte) []string {
+	return strings.Split(string(data), "\n")
+}
+
+func TestSendToPrinterTestPage(t *testing.T) {
+	tests := []struct {
+		name     string
+		printer  Printer
+		codePage byte
+		columns  int
+	}{
+		{"80mm PC437", Printer{Name: "Bar", PaperWidth: 80, CodePage: "PC437"}, 0, 48},
+		{"58mm WPC1252", Printer{Name: "Bar", PaperWidth: 58, CodePage: "WPC1252"}, 16, 32},
+		{"default code page", Printer{Name: "Bar", PaperWidth: 80}, 0, 48},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := RenderTestPage(tt.printer)
+			if err != nil {
+				t.Fatalf("RenderTestPage: %v", err)
+			}
+			got := printJob(t, data)
+
+			if !bytes.Equal(got, data) {
+				t.Fatalf("printer received %d bytes, sent %d", len(got), len(data))
+			}
+			header := append(append([]byte{}, escInit...), 0x1b, 0x74, tt.codePage)
+			if !bytes.HasPrefix(got, header) {
+				t.Errorf("job starts with % x, want % x", got[:len(header)], header)
+			}
+			if !bytes.HasSuffix(got, escCut) {
+				t.Errorf("job does not end with the cut sequence % x", escCut)
+			}
+
+			separator := strings.Repeat("-", tt.columns)
+			found := false
+			for _, line := range printedLines(got) {
+				if line == separator {
+					found = true
+				}
+				if strings.Contains(line, separator+"-") {
+					t.Errorf("line wider than %d columns: %q", tt.columns, line)
+				}
+			}
+			if !found {
+				t.Errorf("no %d column separator in the job", tt.columns)
+			}
+		})
+	}
+}
+
+func TestSendToPrinterReceiptColumns(t *testing.T) {
+	item := OrderItem{ProductName: "Nasi Goreng", UnitPrice: decimal.NewFromInt(25000), Quantity: 2}
+	bill := Bill{
+		TableNumber: 4,
+		Orders:      []Order{{Items: []OrderItem{item}}},
+		Breakdown:   BillBreakdown{Subtotal: decimal.NewFromInt(50000)},
+		TotalAmount: decimal.NewFromInt(50000),
+	}
+
+	for paperWidth, columns := range map[int]int{58: 32, 80: 48} {
+		data, err := RenderReceipt(Printer{PaperWidth: paperWidth}, bill)
+		if err != nil {
+			t.Fatalf("RenderReceipt: %v", err)
+		}
+		got := printJob(t, data)
+
+		want := "2x Nasi Goreng" + strings.Repeat(" ", columns-len("2x Nasi Goreng")-len("50.000")) + "50.000"
+		if !strings.Contains(string(got), want+"\n") {
+			t.Errorf("%dmm receipt has no item line %q", paperWidth, want)
+		}
+		if !bytes.HasSuffix(got, escCut) {
+			t.Errorf("%dmm receipt does not end with the cut sequence", paperWidth)
+		}
+	}
+}
+
+func TestPrinterAddressDefaultPort(t *testing.T) {
+	tests := map[string]string{
+		"192.168.1.50":      "192.168.1.50:9100",
+		"192.168.1.50:9101": "192.168.1.50:9101",
+		"printer.local":     "printer.local:9100",
+		"[fe80::1]:9100":    "[fe80::1]:9100",
+	}
+	for address, want := range tests {
+		if got := printerAddress(address); got != want {
+			t.Errorf("printerAddress(%q) = %q, want %q", address, got, want)
+		}
+	}
+}
+
+func TestSendToPrinterWithoutAddress(t *testing.T) {
+	if err := SendToPrinter("", []byte("x")); err == nil {
+		t.Fatal("SendToPrinter without an address succeeded")
+	}
+}
+
+func TestUnsupportedCodePage(t *testing.T) {
+	_, err := RenderTestPage(Printer{PaperWidth: 80, CodePage: "KATAKANA"})
+	if err == nil || !strings.Contains(err.Error(), "unsupported code page KATAKANA") {
+		t.Fatalf("RenderTestPage error = %v, want unsupported code page", err)
+	}
+}
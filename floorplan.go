@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// MejaDirty marks a table that has been left but not yet cleared
+const MejaDirty = "dirty"
+
+// Live floor plan statuses; awaiting_bill is an occupied table whose bill
+// has been printed
+const (
+	FloorFree         = "free"
+	FloorOccupied     = "occupied"
+	FloorAwaitingBill = "awaiting_bill"
+	FloorDirty        = "dirty"
+)
+
+// Table shapes drawn on the floor plan
+var mejaShapes = []string{"square", "round", "rectangle"}
+
+// FloorPlanTable is a table as drawn on the floor plan with its live status
+type FloorPlanTable struct {
+	ID          uint       `json:"id"`
+	Nama        string     `json:"nama"`
+	Code        *string    `json:"code"`
+	Area        string     `json:"area"`
+	X           int        `json:"x"`
+	Y           int        `json:"y"`
+	Shape       string     `json:"shape"`
+	Seats       int        `json:"seats"`
+	Status      string     `json:"status"`
+	SessionID   *uint      `json:"session_id"`
+	Guests      int        `json:"guests"`
+	SeatedSince *time.Time `json:"seated_since"`
+	MergedInto  *int       `json:"merged_into"` // main table of the merge this table is part of
+}
+
+// FloorPlanFloor groups the tables of one floor
+type FloorPlanFloor struct {
+	Floor  string           `json:"floor"`
+	Tables []FloorPlanTable `json:"tables"`
+}
+
+// SetMejaStatusRequest marks a table dirty after guests leave or free once
+// it has been cleared
+type SetMejaStatusRequest struct {
+	Status string `json:"status"`
+}
+
+// validateMejaLayout checks the floor plan fields of a table
+func validateMejaLayout(meja Meja) string {
+	if meja.PosX < 0 || meja.PosY < 0 {
+		return "Position cannot be negative"
+	}
+	if meja.Shape != "" && !containsString(mejaShapes, meja.Shape) {
+		return "Shape must be one of " + strings.Join(mejaShapes, ", ")
+	}
+	return ""
+}
+
+// GetFloorPlanController returns the tables of every floor, or of the floor
+// given by ?floor=, with their position and live status
+func GetFloorPlanController(c echo.Context) error {
+	query := DB.Order("floor, area, id")
+	if floor := c.QueryParam("floor"); floor != "" {
+		query = query.Where("floor = ?", floor)
+	}
+
+	var mejas []Meja
+	if err := query.Find(&mejas).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve floor plan")
+	}
+
+	var sessions []TableSession
+	if err := DB.Where("status = ?", SessionOpen).Find(&sessions).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve table sessions")
+	}
+	sessionByTable := make(map[int]TableSession, len(sessions))
+	sessionByID := make(map[uint]TableSession, len(sessions))
+	sessionIDs := make([]uint, 0, len(sessions))
+	for _, session := range sessions {
+		sessionByTable[session.TableNumber] = session
+		sessionByID[session.ID] = session
+		sessionIDs = append(sessionIDs, session.ID)
+	}
+
+	// Merged tables show the session they were merged into
+	if len(sessionIDs) > 0 {
+		var merges []TableMerge
+		if err := DB.Where("session_id IN ?", sessionIDs).Find(&merges).Error; err != nil {
+			return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve merged tables")
+		}
+		for _, merge := range merges {
+			sessionByTable[merge.TableNumber] = sessionByID[merge.SessionID]
+		}
+	}
+
+	// Sessions whose bill has been handed over
+	billed := make(map[uint]bool)
+	if len(sessionIDs) > 0 {
+		var billedSessions []uint
+		if err := DB.Model(&Order{}).Distinct("session_id").
+			Where("session_id IN ? AND status = ?", sessionIDs, OrderStatusBilled).
+			Pluck("session_id", &billedSessions).Error; err != nil {
+			return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve order statuses")
+		}
+		for _, id := range billedSessions {
+			billed[id] = true
+		}
+	}
+
+	floors := make(map[string][]FloorPlanTable)
+	for _, meja := range mejas {
+		table := FloorPlanTable{
+			ID:     meja.ID,
+			Nama:   meja.Nama,
+			Code:   meja.Code,
+			Area:   meja.Area,
+			X:      meja.PosX,
+			Y:      meja.PosY,
+			Shape:  meja.Shape,
+			Seats:  meja.Capacity,
+			Status: FloorFree,
+		}
+		if session, ok := sessionByTable[int(meja.ID)]; ok {
+			sessionID, openedAt := session.ID, session.OpenedAt
+			table.SessionID = &sessionID
+			table.Guests = session.Guests
+			table.SeatedSince = &openedAt
+			table.Status = FloorOccupied
+			if session.TableNumber != int(meja.ID) {
+				mergedInto := session.TableNumber
+				table.MergedInto = &mergedInto
+			}
+			if billed[session.ID] {
+				table.Status = FloorAwaitingBill
+			}
+		} else if meja.Status == MejaDirty {
+			table.Status = FloorDirty
+		}
+		floors[meja.Floor] = append(floors[meja.Floor], table)
+	}
+
+	plan := make([]FloorPlanFloor, 0, len(floors))
+	for floor, tables := range floors {
+		plan = append(plan, FloorPlanFloor{Floor: floor, Tables: tables})
+	}
+	sort.Slice(plan, func(i, j int) bool { return plan[i].Floor < plan[j].Floor })
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Floor plan retrieved successfully",
+		Data:    plan,
+	})
+}
+
+// SetMejaStatusController marks a free table dirty or a dirty table free.
+// Occupied tables change status through their session.
+func SetMejaStatusController(c echo.Context) error {
+	var request SetMejaStatusRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	if request.Status != MejaFree && request.Status != MejaDirty {
+		return createErrorResponse(c, http.StatusBadRequest, "Status must be free or dirty")
+	}
+
+	var meja Meja
+	if err := DB.First(&meja, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Meja not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find meja")
+	}
+	if meja.Status == MejaOccupied {
+		return createErrorResponse(c, http.StatusConflict, "Meja is occupied; close its session first")
+	}
+
+	before := auditSnapshot(meja)
+	if err := setMejaStatus(DB, int(meja.ID), request.Status); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update meja status")
+	}
+	meja.Status = request.Status
+	recordAudit(c, DB, AuditUpdate, "meja", meja.ID, before, meja)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Meja status updated successfully",
+		Data:    meja,
+	})
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Order sources
+const (
+	OrderSourceStaff = "staff"
+	OrderSourceGuest = "guest"
+)
+
+// GuestOrderSetting controls ordering from the table QR codes. There is a
+// single row; without it guests may order and nothing needs approval.
+type GuestOrderSetting struct {
+	ID              uint `gorm:"primaryKey" json:"id"`
+	Enabled         bool `gorm:"not null;default:true" json:"enabled"`
+	RequireApproval bool `gorm:"not null;default:false" json:"require_approval"` // hold guest orders until staff approve them
+}
+
+const guestOrderSettingID = 1
+
+// GuestOrderRequest is an order placed by a guest from the table QR code
+type GuestOrderRequest struct {
+	Items []OrderItemRequest `json:"items"`
+}
+
+// RejectOrderRequest gives the reason a held guest order was turned down
+type RejectOrderRequest struct {
+	Reason string `json:"reason"`
+}
+
+// GuestMenuCategory is a category of the guest menu with its products
+type GuestMenuCategory struct {
+	Category Category  `json:"category"`
+	Products []Product `json:"products"`
+}
+
+var errInvalidQRToken = errors.New("invalid table QR code")
+
+var (
+	qrSecretOnce sync.Once
+	qrSecretKey  []byte
+)
+
+// qrSecret is the key table QR tokens are signed with. Without QR_SECRET a
+// random key is used and printed QR codes stop working on restart.
+func qrSecret() []byte {
+	qrSecretOnce.Do(func() {
+		if secret := os.Getenv("QR_SECRET"); secret != "" {
+			qrSecretKey = []byte(secret)
+			return
+		}
+		log.Println("QR_SECRET is not set; table QR codes will change on restart")
+		qrSecretKey = make([]byte, 32)
+		if _, err := rand.Read(qrSecretKey); err != nil {
+			log.Fatalf("Failed to generate QR secret: %v", err)
+		}
+	})
+	return qrSecretKey
+}
+
+// qrSignature signs the table ID and QR version of a token
+func qrSignature(payload string) string {
+	mac := hmac.New(sha256.New, qrSecret())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// mejaQRToken is the token printed in the QR code of a table, e.g.
+// "3.1.<signature>". Rotating the table's QR version invalidates it.
+func mejaQRToken(meja Meja) string {
+	payload := fmt.Sprintf("%d.%d", meja.ID, meja.QRVersion)
+	return payload + "." + qrSignature(payload)
+}
+
+// parseQRToken checks the signature and version of a QR token and returns
+// its table
+func parseQRToken(db *gorm.DB, token string) (Meja, error) {
+	var meja Meja
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return meja, errInvalidQRToken
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(qrSignature(payload))) {
+		return meja, errInvalidQRToken
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return meja, errInvalidQRToken
+	}
+	version, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return meja, errInvalidQRToken
+	}
+
+	if err := db.First(&meja, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return meja, errInvalidQRToken
+		}
+		return meja, err
+	}
+	if meja.QRVersion != version {
+		return meja, errInvalidQRToken
+	}
+	return meja, nil
+}
+
+// loadGuestOrderSetting returns the guest ordering setting, or the defaults
+// when none is saved
+func loadGuestOrderSetting(db *gorm.DB) (GuestOrderSetting, error) {
+	var setting GuestOrderSetting
+	err := db.First(&setting, guestOrderSettingID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return GuestOrderSetting{ID: guestOrderSettingID, Enabled: true}, nil
+	}
+	return setting, err
+}
+
+// guestTable resolves the QR token in the request path. It returns a status
+// and message when the token is not valid.
+func guestTable(c echo.Context) (Meja, int, string) {
+	meja, err := parseQRToken(DB, c.Param("token"))
+	if errors.Is(err, errInvalidQRToken) {
+		return meja, http.StatusNotFound, "This QR code is not valid; please ask our staff"
+	}
+	if err != nil {
+		return meja, http.StatusInternalServerError, "Failed to find table"
+	}
+	return meja, 0, ""
+}
+
+// cancelPendingOrders turns down the held orders of a session and puts
+// their ingredients back in stock
+func cancelPendingOrders(tx *gorm.DB, sessionID uint, reason string) error {
+	var orders []Order
+	if err := tx.Where("session_id = ? AND status = ?", sessionID, OrderStatusPendingApproval).Find(&orders).Error; err != nil {
+		return err
+	}
+	for i := range orders {
+		if err := transitionOrder(tx, &orders[i], OrderStatusCancelled, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTableQRController returns the QR token of a table
+func GetTableQRController(c echo.Context) error {
+	var meja Meja
+	if err := DB.First(&meja, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Meja not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve meja")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "QR token retrieved successfully",
+		Data: map[string]interface{}{
+			"meja_id": meja.ID,
+			"token":   mejaQRToken(meja),
+			"menu":    "/api/v1/guest/" + mejaQRToken(meja) + "/menu",
+		},
+	})
+}
+
+// RotateTableQRController invalidates the printed QR code of a table and
+// returns the new token
+func RotateTableQRController(c echo.Context) error {
+	var meja Meja
+	if err := DB.First(&meja, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Meja not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve meja")
+	}
+
+	before := auditSnapshot(meja)
+	if err := DB.Model(&meja).Update("qr_version", gorm.Expr("qr_version + 1")).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to rotate QR token")
+	}
+	meja.QRVersion++
+	recordAudit(c, DB, AuditUpdate, "meja", meja.ID, before, meja)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "QR token rotated successfully",
+		Data: map[string]interface{}{
+			"meja_id": meja.ID,
+			"token":   mejaQRToken(meja),
+		},
+	})
+}
+
+// GetGuestOrderSettingController returns the guest ordering setting
+func GetGuestOrderSettingController(c echo.Context) error {
+	setting, err := loadGuestOrderSetting(DB)
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve guest order setting")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Guest order setting retrieved successfully",
+		Data:    setting,
+	})
+}
+
+// UpdateGuestOrderSettingController turns guest ordering and approval on
+// or off
+func UpdateGuestOrderSettingController(c echo.Context) error {
+	var setting GuestOrderSetting
+	if err := c.Bind(&setting); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	before, err := loadGuestOrderSetting(DB)
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve guest order setting")
+	}
+
+	setting.ID = guestOrderSettingID
+	if err := DB.Save(&setting).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update guest order setting")
+	}
+	recordAudit(c, DB, AuditUpdate, "guest_order_setting", setting.ID, before, setting)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Guest order setting updated successfully",
+		Data:    setting,
+	})
+}
+
+// GetGuestMenuController lists the products a guest can order right now,
+// grouped by category in menu order
+func GetGuestMenuController(c echo.Context) error {
+	meja, status, message := guestTable(c)
+	if status != 0 {
+		return createErrorResponse(c, status, message)
+	}
+
+	var products []Product
+	if err := DB.Preload("Category").
+		Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("sort_order, id") }).
+		Preload("ModifierGroups.Modifiers").
+		Where("available = ?", true).Order("name").Find(&products).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve menu")
+	}
+	if err := availableNow(DB, products, time.Now()); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve menu")
+	}
+
+	byCategory := make(map[uint]*GuestMenuCategory)
+	for _, product := range products {
+		if !product.AvailableNow || product.Category == nil {
+			continue
+		}
+		group, ok := byCategory[product.Category.ID]
+		if !ok {
+			group = &GuestMenuCategory{Category: *product.Category}
+			byCategory[product.Category.ID] = group
+		}
+		group.Products = append(group.Products, product)
+	}
+
+	menu := make([]GuestMenuCategory, 0, len(byCategory))
+	for _, group := range byCategory {
+		menu = append(menu, *group)
+	}
+	sort.Slice(menu, func(i, j int) bool {
+		if menu[i].Category.SortOrder != menu[j].Category.SortOrder {
+			return menu[i].Category.SortOrder < menu[j].Category.SortOrder
+		}
+		return menu[i].Category.Nama < menu[j].Category.Nama
+	})
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Menu retrieved successfully",
+		Data: map[string]interface{}{
+			"table": map[string]interface{}{
+				"id":   meja.ID,
+				"nama": meja.Nama,
+				"code": meja.Code,
+			},
+			"menu": menu,
+		},
+	})
+}
+
+// CreateGuestOrderController places an order for the table of the QR code.
+// When approval is required the order waits for staff before printing.
+func CreateGuestOrderController(c echo.Context) error {
+	meja, status, message := guestTable(c)
+	if status != 0 {
+		return createErrorResponse(c, status, message)
+	}
+
+	var request GuestOrderRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	if len(request.Items) == 0 {
+		return createErrorResponse(c, http.StatusBadRequest, "No items to order")
+	}
+
+	setting, err := loadGuestOrderSetting(DB)
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve guest order setting")
+	}
+	if !setting.Enabled {
+		return createErrorResponse(c, http.StatusForbidden, "Ordering from the table is switched off; please ask our staff")
+	}
+
+	tx := DB.Begin()
+	if tx.Error != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to start transaction")
+	}
+	defer func() {
+		if r := recover(); r != nil || tx.Error != nil {
+			tx.Rollback()
+		}
+	}()
+
+	orderRequest := CreateOrderRequest{TableNumber: int(meja.ID), Items: request.Items}
+	placed, status, message := placeOrder(tx, orderRequest, OrderSourceGuest, setting.RequireApproval)
+	if status != 0 {
+		return createErrorResponse(c, status, message)
+	}
+	recordAudit(c, tx, AuditCreate, "order", placed.Order.ID, nil, placed.Order)
+
+	if err := tx.Commit().Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
+	}
+
+	message = "Order sent to the kitchen"
+	if setting.RequireApproval {
+		message = "Order received; our staff will confirm it shortly"
+	}
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: message,
+		Data: map[string]interface{}{
+			"order_id": placed.Order.ID,
+			"status":   placed.Order.Status,
+		},
+	})
+}
+
+// GetPendingOrdersController lists guest orders waiting for approval
+func GetPendingOrdersController(c echo.Context) error {
+	var orders []Order
+	if err := DB.Preload("Items", "voided_at IS NULL").Preload("Items.Product").Preload("Items.Variant").Preload("Items.Modifiers").
+		Where("status = ?", OrderStatusPendingApproval).Order("created_at").Find(&orders).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve pending orders")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Pending orders retrieved successfully",
+		Data:    orders,
+	})
+}
+
+// ApproveOrderController sends a held guest order to the kitchen printers
+func ApproveOrderController(c echo.Context) error {
+	var (
+		order            Order
+		responsePrinters map[string][]string
+		debugInfo        []string
+		status           = http.StatusInternalServerError
+		message          = "Failed to approve order"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Items", "voided_at IS NULL").Preload("Items.Product.Category").
+			First(&order, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "Order not found"
+			}
+			return err
+		}
+		if order.Status != OrderStatusPendingApproval {
+			status, message = http.StatusConflict, "Order is "+order.Status+", not waiting for approval"
+			return errOrderItemRejected
+		}
+
+		responsePrinters, debugInfo = routeOrderItems(tx, order.ID, order.Items)
+		if responsePrinters == nil {
+			message = strings.Join(debugInfo, "; ")
+			return errOrderItemRejected
+		}
+
+		before := auditSnapshot(order)
+		next := OrderStatusOpen
+		if len(responsePrinters) > 0 {
+			next = OrderStatusSentToKitchen
+		}
+		if err := transitionOrder(tx, &order, next, "Approved"); err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "order", order.ID, before, order)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Order approved successfully",
+		Data: map[string]interface{}{
+			"order_id":   order.ID,
+			"status":     order.Status,
+			"printers":   responsePrinters,
+			"debug_info": debugInfo,
+		},
+	})
+}
+
+// RejectOrderController turns down a held guest order
+func RejectOrderController(c echo.Context) error {
+	var request RejectOrderRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+
+	var (
+		order   Order
+		status  = http.StatusInternalServerError
+		message = "Failed to reject order"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "Order not found"
+			}
+			return err
+		}
+		if order.Status != OrderStatusPendingApproval {
+			status, message = http.StatusConflict, "Order is "+order.Status+", not waiting for approval"
+			return errOrderItemRejected
+		}
+
+		reason := strings.TrimSpace(request.Reason)
+		if reason == "" {
+			reason = "Rejected"
+		}
+		before := auditSnapshot(order)
+		if err := transitionOrder(tx, &order, OrderStatusCancelled, reason); err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "order", order.ID, before, order)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Order rejected",
+		Data:    order,
+	})
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Stock movement reasons
+const (
+	StockReasonOrder      = "order"
+	StockReasonRestock    = "restock"
+	StockReasonAdjustment = "adjustment"
+	StockReasonWaste      = "waste"
+	StockReasonReturn     = "return" // ingredients of a cancelled order or voided item put back
+)
+
+// Ingredient is a stocked item used by product recipes. Stock and
+// ReorderLevel are counted in Unit, e.g. gram, ml or pcs.
+type Ingredient struct {
+	gorm.Model
+	Nama         string          `gorm:"size:100;not null;uniqueIndex" json:"nama"`
+	Unit         string          `gorm:"size:20;not null" json:"unit"`
+	Stock        decimal.Decimal `gorm:"not null;type:decimal(12,3);default:0" json:"stock"`
+	ReorderLevel decimal.Decimal `gorm:"not null;type:decimal(12,3);default:0" json:"reorder_level"`
+}
+
+// Recipe is the quantity of an ingredient used by one unit of a product.
+// Products without a recipe are not stock-tracked.
+type Recipe struct {
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	ProductID    uint            `gorm:"not null;uniqueIndex:idx_recipe_product_ingredient" json:"product_id"`
+	IngredientID uint            `gorm:"not null;uniqueIndex:idx_recipe_product_ingredient" json:"ingredient_id"`
+	Quantity     decimal.Decimal `gorm:"not null;type:decimal(12,3)" json:"quantity"`
+	Ingredient   Ingredient      `gorm:"foreignKey:IngredientID" json:"ingredient"`
+}
+
+// StockMovement records every change to an ingredient's stock. Change is
+// negative when stock is used.
+type StockMovement struct {
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	IngredientID uint            `gorm:"not null;index" json:"ingredient_id"`
+	Change       decimal.Decimal `gorm:"not null;type:decimal(12,3)" json:"change"`
+	Balance      decimal.Decimal `gorm:"not null;type:decimal(12,3)" json:"balance"` // stock after the movement
+	Reason       string          `gorm:"size:20;not null" json:"reason"`
+	OrderID      *uint           `gorm:"index" json:"order_id"`
+	OrderItemID  *uint           `json:"order_item_id"`
+	Note         string          `gorm:"size:255" json:"note"`
+	CreatedAt    time.Time       `gorm:"index" json:"created_at"`
+}
+
+// SetRecipeRequest replaces the recipe of a product
+type SetRecipeRequest struct {
+	Items []struct {
+		IngredientID uint            `json:"ingredient_id"`
+		Quantity     decimal.Decimal `json:"quantity"`
+	} `json:"items"`
+}
+
+// AdjustStockRequest adds or removes stock by hand. Change is positive for
+// deliveries and negative for waste or stock-take corrections.
+type AdjustStockRequest struct {
+	Change decimal.Decimal `json:"change"`
+	Reason string          `json:"reason"`
+	Note   string          `json:"note"`
+}
+
+// errOutOfStock is returned when an ingredient cannot cover an order item
+var errOutOfStock = errors.New("out of stock")
+
+// errInvalidStockQuantity is returned when an order item would not take a
+// positive amount out of stock
+var errInvalidStockQuantity = errors.New("stock can only be deducted for a positive quantity")
+
+// deductStock takes the recipe ingredients of an order item out of stock.
+// Ingredient rows are locked so concurrent orders cannot oversell.
+func deductStock(tx *gorm.DB, item OrderItem, product Product) error {
+	if item.Quantity <= 0 {
+		return errInvalidStockQuantity
+	}
+
+	var recipe []Recipe
+	if err := tx.Where("product_id = ?", product.ID).Order("ingredient_id").Find(&recipe).Error; err != nil {
+		return err
+	}
+
+	quantity := decimal.NewFromInt(int64(item.Quantity))
+	for _, line := range recipe {
+		var ingredient Ingredient
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ingredient, line.IngredientID).Error; err != nil {
+			return err
+		}
+
+		needed := line.Quantity.Mul(quantity)
+		if !needed.IsPositive() {
+			return errInvalidStockQuantity
+		}
+		if ingredient.Stock.LessThan(needed) {
+			return fmt.Errorf("%s is %w: needs %s %s of %s, %s left", product.Name, errOutOfStock,
+				needed.String(), ingredient.Unit, ingredient.Nama, ingredient.Stock.String())
+		}
+
+		orderID, orderItemID := item.OrderID, item.ID
+		if err := moveStock(tx, &ingredient, needed.Neg(), StockReasonOrder, &orderID, &orderItemID, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreOrderStock puts back the ingredients taken for an order that was
+// never made. Whatever voids already returned is not put back twice.
+func restoreOrderStock(tx *gorm.DB, orderID uint) error {
+	var movements []StockMovement
+	if err := tx.Where("order_id = ? AND reason IN ?", orderID, []string{StockReasonOrder, StockReasonReturn}).
+		Order("id").Find(&movements).Error; err != nil {
+		return err
+	}
+
+	// Net change per ingredient of each item
+	type stockKey struct {
+		ingredientID uint
+		orderItemID  uint
+	}
+	var keys []stockKey
+	taken := make(map[stockKey]decimal.Decimal)
+	for _, movement := range movements {
+		key := stockKey{ingredientID: movement.IngredientID}
+		if movement.OrderItemID != nil {
+			key.orderItemID = *movement.OrderItemID
+		}
+		if _, seen := taken[key]; !seen {
+			keys = append(keys, key)
+		}
+		taken[key] = taken[key].Add(movement.Change)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ingredientID < keys[j].ingredientID })
+
+	for _, key := range keys {
+		if !taken[key].IsNegative() {
+			continue
+		}
+		var ingredient Ingredient
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ingredient, key.ingredientID).Error; err != nil {
+			return err
+		}
+		orderItemID := key.orderItemID
+		if err := moveStock(tx, &ingredient, taken[key].Neg(), StockReasonReturn, &orderID, &orderItemID, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreItemStock puts back the ingredients of quantity voided units of an
+// order item, out of the ordered units taken from stock when it was ordered
+func restoreItemStock(tx *gorm.DB, item OrderItem, quantity, ordered int) error {
+	if quantity <= 0 || ordered <= 0 {
+		return nil
+	}
+
+	var movements []StockMovement
+	if err := tx.Where("order_item_id = ? AND reason = ?", item.ID, StockReasonOrder).Order("ingredient_id").Find(&movements).Error; err != nil {
+		return err
+	}
+	share := decimal.NewFromInt(int64(quantity)).Div(decimal.NewFromInt(int64(ordered)))
+	for _, movement := range movements {
+		var ingredient Ingredient
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ingredient, movement.IngredientID).Error; err != nil {
+			return err
+		}
+		back := movement.Change.Neg().Mul(share).Round(3)
+		if err := moveStock(tx, &ingredient, back, StockReasonReturn, movement.OrderID, movement.OrderItemID, "Voided"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveStock applies a change to an ingredient and records the movement
+func moveStock(tx *gorm.DB, ingredient *Ingredient, change decimal.Decimal, reason string, orderID, orderItemID *uint, note string) error {
+	ingredient.Stock = ingredient.Stock.Add(change)
+	if err := tx.Model(ingredient).Update("stock", ingredient.Stock).Error; err != nil {
+		return err
+	}
+	return tx.Create(&StockMovement{
+		IngredientID: ingredient.ID,
+		Change:       change,
+		Balance:      ingredient.Stock,
+		Reason:       reason,
+		OrderID:      orderID,
+		OrderItemID:  orderItemID,
+		Note:         note,
+	}).Error
+}
+
+// validateIngredient checks the fields of an ingredient
+func validateIngredient(ingredient Ingredient) string {
+	if strings.TrimSpace(ingredient.Nama) == "" {
+		return "Nama is required"
+	}
+	if strings.TrimSpace(ingredient.Unit) == "" {
+		return "Unit is required"
+	}
+	if ingredient.ReorderLevel.IsNegative() {
+		return "reorder_level cannot be negative"
+	}
+	return ""
+}
+
+// CreateIngredientController adds an ingredient. Opening stock is recorded
+// as a restock movement.
+func CreateIngredientController(c echo.Context) error {
+	var ingredient Ingredient
+	if err := c.Bind(&ingredient); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+	if message := validateIngredient(ingredient); message != "" {
+		return createErrorResponse(c, http.StatusBadRequest, message)
+	}
+	if ingredient.Stock.IsNegative() {
+		return createErrorResponse(c, http.StatusBadRequest, "stock cannot be negative")
+	}
+
+	var existingIngredient Ingredient
+	if err := DB.Where("nama = ?", ingredient.Nama).First(&existingIngredient).Error; err == nil {
+		return createErrorResponse(c, http.StatusConflict, "Ingredient with nama "+ingredient.Nama+" already exists")
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		openingStock := ingredient.Stock
+		ingredient.Stock = decimal.Zero
+		if err := tx.Create(&ingredient).Error; err != nil {
+			return err
+		}
+		if !openingStock.IsZero() {
+			if err := moveStock(tx, &ingredient, openingStock, StockReasonRestock, nil, nil, "Opening stock"); err != nil {
+				return err
+			}
+		}
+		recordAudit(c, tx, AuditCreate, "ingredient", ingredient.ID, nil, ingredient)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to create ingredient")
+	}
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Ingredient created successfully",
+		Data:    ingredient,
+	})
+}
+
+// GetIngredientsController lists ingredients with their stock
+func GetIngredientsController(c echo.Context) error {
+	var ingredients []Ingredient
+	if err := DB.Order("nama").Find(&ingredients).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve ingredients")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Ingredients retrieved successfully",
+		Data:    ingredients,
+	})
+}
+
+// UpdateIngredientController updates an ingredient. Stock only changes
+// through stock adjustments so every change is recorded.
+func UpdateIngredientController(c echo.Context) error {
+	var ingredient Ingredient
+	if err := DB.First(&ingredient, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Ingredient not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve ingredient")
+	}
+
+	var updatedIngredient Ingredient
+	if err := c.Bind(&updatedIngredient); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+	if message := validateIngredient(updatedIngredient); message != "" {
+		return createErrorResponse(c, http.StatusBadRequest, message)
+	}
+
+	before := auditSnapshot(ingredient)
+	ingredient.Nama = updatedIngredient.Nama
+	ingredient.Unit = updatedIngredient.Unit
+	ingredient.ReorderLevel = updatedIngredient.ReorderLevel
+	if err := DB.Save(&ingredient).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update ingredient")
+	}
+	recordAudit(c, DB, AuditUpdate, "ingredient", ingredient.ID, before, ingredient)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Ingredient updated successfully",
+		Data:    ingredient,
+	})
+}
+
+// DeleteIngredientController soft-deletes an ingredient no recipe uses
+func DeleteIngredientController(c echo.Context) error {
+	var ingredient Ingredient
+	if err := DB.First(&ingredient, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Ingredient not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve ingredient")
+	}
+
+	var recipes int64
+	if err := DB.Model(&Recipe{}).Where("ingredient_id = ?", ingredient.ID).Count(&recipes).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to check recipes")
+	}
+	if recipes > 0 {
+		return createErrorResponse(c, http.StatusConflict, fmt.Sprintf("Ingredient is still used by %d recipes", recipes))
+	}
+
+	if err := DB.Delete(&ingredient).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to delete ingredient")
+	}
+	recordAudit(c, DB, AuditSoftDelete, "ingredient", ingredient.ID, ingredient, nil)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Ingredient deleted successfully",
+		Data:    nil,
+	})
+}
+
+// AdjustStockController records a delivery, waste or stock-take correction
+func AdjustStockController(c echo.Context) error {
+	var request AdjustStockRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+	if request.Change.IsZero() {
+		return createErrorResponse(c, http.StatusBadRequest, "change cannot be zero")
+	}
+
+	request.Reason = strings.ToLower(request.Reason)
+	if request.Reason == "" {
+		request.Reason = StockReasonRestock
+		if request.Change.IsNegative() {
+			request.Reason = StockReasonAdjustment
+		}
+	}
+	if request.Reason != StockReasonRestock && request.Reason != StockReasonAdjustment && request.Reason != StockReasonWaste {
+		return createErrorResponse(c, http.StatusBadRequest, "reason must be restock, adjustment or waste")
+	}
+
+	var (
+		ingredient Ingredient
+		status     = http.StatusInternalServerError
+		message    = "Failed to adjust stock"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ingredient, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "Ingredient not found"
+			}
+			return err
+		}
+		if ingredient.Stock.Add(request.Change).IsNegative() {
+			status, message = http.StatusConflict, "Stock cannot go below zero"
+			return errOutOfStock
+		}
+		before := auditSnapshot(ingredient)
+		if err := moveStock(tx, &ingredient, request.Change, request.Reason, nil, nil, strings.TrimSpace(request.Note)); err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "ingredient", ingredient.ID, before, ingredient)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Stock adjusted successfully",
+		Data:    ingredient,
+	})
+}
+
+// GetStockMovementsController lists the stock movements of an ingredient,
+// newest first
+func GetStockMovementsController(c echo.Context) error {
+	var movements []StockMovement
+	if err := DB.Where("ingredient_id = ?", c.Param("id")).Order("id DESC").Limit(500).Find(&movements).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve stock movements")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Stock movements retrieved successfully",
+		Data:    movements,
+	})
+}
+
+// GetRecipeController returns the recipe of a product
+func GetRecipeController(c echo.Context) error {
+	var recipe []Recipe
+	if err := DB.Preload("Ingredient").Where("product_id = ?", c.Param("id")).Order("id").Find(&recipe).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve recipe")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Recipe retrieved successfully",
+		Data:    recipe,
+	})
+}
+
+// SetRecipeController replaces the recipe of a product. An empty list stops
+// stock tracking for the product.
+func SetRecipeController(c echo.Context) error {
+	var product Product
+	if err := DB.First(&product, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Product not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve product")
+	}
+
+	var request SetRecipeRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	recipe := make([]Recipe, 0, len(request.Items))
+	seen := make(map[uint]bool)
+	for _, item := range request.Items {
+		if !item.Quantity.IsPositive() {
+			return createErrorResponse(c, http.StatusBadRequest, "Recipe quantities must be greater than zero")
+		}
+		if seen[item.IngredientID] {
+			return createErrorResponse(c, http.StatusBadRequest, "Each ingredient can appear only once")
+		}
+		seen[item.IngredientID] = true
+
+		var ingredient Ingredient
+		if err := DB.First(&ingredient, item.IngredientID).Error; err != nil {
+			return createErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("Ingredient %d not found", item.IngredientID))
+		}
+		recipe = append(recipe, Recipe{ProductID: product.ID, IngredientID: ingredient.ID, Quantity: item.Quantity, Ingredient: ingredient})
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var before []Recipe
+		if err := tx.Where("product_id = ?", product.ID).Order("id").Find(&before).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("product_id = ?", product.ID).Delete(&Recipe{}).Error; err != nil {
+			return err
+		}
+		if len(recipe) > 0 {
+			if err := tx.Omit("Ingredient").Create(&recipe).Error; err != nil {
+				return err
+			}
+		}
+		recordAudit(c, tx, AuditUpdate, "recipe", product.ID, before, recipe)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to save recipe")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Recipe saved successfully",
+		Data:    recipe,
+	})
+}
+
+// GetLowStockController lists ingredients at or below their reorder level,
+// emptiest first, with the products that use them
+func GetLowStockController(c echo.Context) error {
+	var ingredients []Ingredient
+	if err := DB.Where("stock <= reorder_level").Order("stock - reorder_level, nama").Find(&ingredients).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve low stock")
+	}
+
+	report := make([]map[string]interface{}, 0, len(ingredients))
+	for _, ingredient := range ingredients {
+		var products []string
+		if err := DB.Model(&Product{}).Where("id IN (?)", DB.Model(&Recipe{}).Select("product_id").Where("ingredient_id = ?", ingredient.ID)).
+			Order("name").Pluck("name", &products).Error; err != nil {
+			return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve low stock")
+		}
+		report = append(report, map[string]interface{}{
+			"ingredient":   ingredient,
+			"shortfall":    ingredient.ReorderLevel.Sub(ingredient.Stock),
+			"out_of_stock": !ingredient.Stock.IsPositive(),
+			"used_by":      products,
+		})
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Low stock retrieved successfully",
+		Data:    report,
+	})
+}
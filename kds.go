@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Kitchen ticket statuses, in the order a ticket moves through them
+const (
+	TicketQueued  = "queued"
+	TicketCooking = "cooking"
+	TicketReady   = "ready"
+	TicketServed  = "served"
+)
+
+// ticketTransitions lists the next status allowed from each ticket status
+var ticketTransitions = map[string]string{
+	TicketQueued:  TicketCooking,
+	TicketCooking: TicketReady,
+	TicketReady:   TicketServed,
+}
+
+// KitchenTicket is the work a single printer station has to do for an order
+type KitchenTicket struct {
+	gorm.Model
+	OrderID   uint   `gorm:"not null;index"`
+	PrinterID string `gorm:"size:1;not null;index"`
+	Status    string `gorm:"size:20;not null;default:queued;index"`
+	StartedAt *time.Time
+	ReadyAt   *time.Time
+	ServedAt  *time.Time
+	Order     Order               `gorm:"foreignKey:OrderID;references:ID"`
+	Items     []KitchenTicketItem `gorm:"foreignKey:KitchenTicketID"`
+}
+
+// KitchenTicketItem links an order item to the ticket of the station cooking it
+type KitchenTicketItem struct {
+	ID              uint      `gorm:"primaryKey"`
+	KitchenTicketID uint      `gorm:"not null;index"`
+	OrderItemID     uint      `gorm:"not null;index"`
+	OrderItem       OrderItem `gorm:"foreignKey:OrderItemID;references:ID"`
+}
+
+// liveTicketItems limits a ticket's items to those whose order item has not
+// been voided
+func liveTicketItems(db *gorm.DB) *gorm.DB {
+	live := db.Session(&gorm.Session{NewDB: true}).Model(&OrderItem{}).Select("id").Where("voided_at IS NULL AND quantity > 0")
+	return db.Where("order_item_id IN (?)", live)
+}
+
+// UpdateTicketStatusRequest moves a kitchen ticket to its next status
+type UpdateTicketStatusRequest struct {
+	Status string `json:"status"`
+}
+
+// addToKitchenTicket puts an order item on the ticket of the given station,
+// creating the ticket the first time the station is used for the order
+func addToKitchenTicket(tx *gorm.DB, tickets map[string]*KitchenTicket, orderID uint, printerID string, orderItemID uint) error {
+	ticket, ok := tickets[printerID]
+	if !ok {
+		ticket = &KitchenTicket{
+			OrderID:   orderID,
+			PrinterID: printerID,
+			Status:    TicketQueued,
+		}
+		if err := tx.Create(ticket).Error; err != nil {
+			return err
+		}
+		tickets[printerID] = ticket
+	}
+
+	item := KitchenTicketItem{
+		KitchenTicketID: ticket.ID,
+		OrderItemID:     orderItemID,
+	}
+	return tx.Create(&item).Error
+}
+
+// GetStationTicketsController returns the open ticket queue of a printer station.
+// Served tickets are only included when asked for with ?status=served.
+func GetStationTicketsController(c echo.Context) error {
+	printerID := c.Param("printer_id")
+	status := c.QueryParam("status")
+
+	var printer Printer
+	if err := DB.First(&printer, "id = ?", printerID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Printer not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find printer")
+	}
+
+	query := DB.Preload("Order").Preload("Items", liveTicketItems).Preload("Items.OrderItem.Product").Preload("Items.OrderItem.Variant").Preload("Items.OrderItem.Modifiers").
+		Where("printer_id = ?", printerID)
+	if status != "" {
+		if _, known := ticketTransitions[status]; !known && status != TicketServed {
+			return createErrorResponse(c, http.StatusBadRequest, "Invalid ticket status")
+		}
+		query = query.Where("status = ?", status)
+	} else {
+		query = query.Where("status <> ?", TicketServed)
+	}
+
+	var tickets []KitchenTicket
+	if err := query.Order("created_at asc").Find(&tickets).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve tickets")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Tickets retrieved successfully",
+		Data:    tickets,
+	})
+}
+
+// GetTicketController returns a single kitchen ticket with its items
+func GetTicketController(c echo.Context) error {
+	id := c.Param("id")
+
+	var ticket KitchenTicket
+	if err := DB.Preload("Order").Preload("Items", liveTicketItems).Preload("Items.OrderItem.Product").Preload("Items.OrderItem.Variant").Preload("Items.OrderItem.Modifiers").First(&ticket, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Ticket not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve ticket")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Ticket retrieved successfully",
+		Data:    ticket,
+	})
+}
+
+// UpdateTicketStatusController moves a ticket one step through
+// queued -> cooking -> ready -> served
+func UpdateTicketStatusController(c echo.Context) error {
+	id := c.Param("id")
+
+	var request UpdateTicketStatusRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+
+	var ticket KitchenTicket
+	if err := DB.First(&ticket, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Ticket not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve ticket")
+	}
+
+	if next, ok := ticketTransitions[ticket.Status]; !ok || next != request.Status {
+		return createErrorResponse(c, http.StatusConflict, "Ticket cannot move from "+ticket.Status+" to "+request.Status)
+	}
+
+	before := auditSnapshot(ticket)
+	now := time.Now()
+	ticket.Status = request.Status
+	switch request.Status {
+	case TicketCooking:
+		ticket.StartedAt = &now
+	case TicketReady:
+		ticket.ReadyAt = &now
+	case TicketServed:
+		ticket.ServedAt = &now
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&ticket).Error; err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "kitchen_ticket", ticket.ID, before, ticket)
+		if ticket.Status != TicketServed {
+			return nil
+		}
+
+		// The order is served once every station has served its ticket
+		var waiting int64
+		if err := tx.Model(&KitchenTicket{}).
+			Where("order_id = ? AND status <> ?", ticket.OrderID, TicketServed).
+			Count(&waiting).Error; err != nil {
+			return err
+		}
+		if waiting > 0 {
+			return nil
+		}
+		return transitionOrders(tx, []uint{ticket.OrderID}, OrderStatusServed, "All kitchen tickets served")
+	})
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update ticket")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Ticket updated successfully",
+		Data:    ticket,
+	})
+}
@@ -7,9 +7,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/shopspring/decimal"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -17,24 +23,34 @@ import (
 // Product represents a product in the database
 type Product struct {
 	gorm.Model
-	ID       uint    `gorm:"primaryKey"`
-	Category string  `gorm:"not null"`
-	Name     string  `gorm:"not null"`
-	Varian   string  `gorm:"not null"`
-	Price    float64 `gorm:"not null;type:decimal(10,2)"`
+	ID         uint            `gorm:"primaryKey"`
+	CategoryID *uint           `gorm:"index"`
+	Name       string          `gorm:"not null"`
+	Varian     string          `gorm:"not null"`
+	Price      decimal.Decimal `gorm:"not null;type:decimal(10,2)"`
+	Available  bool            `gorm:"not null;default:true"` // false while the kitchen has run out
+	Category   *Category       `gorm:"foreignKey:CategoryID"`
+
+	AvailableNow bool `gorm:"-"` // Available and inside a schedule window
+
+	Variants       []ProductVariant `gorm:"foreignKey:ProductID"`
+	ModifierGroups []ModifierGroup  `gorm:"many2many:product_modifier_groups;"`
 }
 
 type UpdateOrderRequest struct {
-	TableNumber int `json:"table_number"`
-	Status      int `json:"status"`
+	TableNumber int    `json:"table_number"`
+	Status      string `json:"status"`
+	Note        string `json:"note"`
 }
 
 // Order represents an order with its items
 type Order struct {
 	gorm.Model
-	ID          uint `gorm:"primaryKey"`
-	TableNumber int  // Use int here
-	Status      int
+	ID          uint   `gorm:"primaryKey"`
+	TableNumber int    // Use int here
+	SessionID   *uint  `gorm:"index"` // the table session the order was placed in
+	Status      string `gorm:"size:20;not null;default:open;index"`
+	Source      string `gorm:"size:10;not null;default:staff"` // staff, or guest for QR orders
 	Items       []OrderItem
 }
 
@@ -44,7 +60,21 @@ type OrderItem struct {
 	OrderID   uint
 	ProductID uint
 	Quantity  int
-	Product   Product `gorm:"foreignKey:ProductID;references:ID"`
+	Seat      int        `gorm:"not null;default:0"` // seat number at the table, 0 when shared
+	VoidedAt  *time.Time // set when the whole item has been voided
+	VariantID *uint
+
+	// Copied from the product and variant when ordered, so menu edits do
+	// not change past orders
+	ProductName       string          `gorm:"size:255"`
+	ProductVarian     string          `gorm:"size:255"`
+	UnitPrice         decimal.Decimal `gorm:"not null;type:decimal(10,2);default:0"` // product price
+	VariantName       string          `gorm:"size:100"`
+	VariantPriceDelta decimal.Decimal `gorm:"not null;type:decimal(10,2);default:0"`
+
+	Product   Product             `gorm:"foreignKey:ProductID;references:ID"`
+	Variant   *ProductVariant     `gorm:"foreignKey:VariantID"`
+	Modifiers []OrderItemModifier `gorm:"foreignKey:OrderItemID"`
 }
 
 // OrderPrinter represents printers assigned to an order
@@ -58,37 +88,71 @@ type OrderPrinter struct {
 // Printer represents a printer in the system
 type Printer struct {
 	gorm.Model
-	ID   string `gorm:"size:1;primaryKey"`
-	Name string `gorm:"size:50;uniqueIndex"`
+	ID         string `gorm:"size:1;primaryKey"`
+	Name       string `gorm:"size:50;uniqueIndex"`
+	Address    string `gorm:"size:100"`                       // host or host:port of a raw TCP printer
+	PaperWidth int    `gorm:"not null;default:80"`            // paper width in mm, 58 or 80
+	CodePage   string `gorm:"size:20;not null;default:PC437"` // ESC/POS code page
 }
 
-// Promo represents a promotional discount
+// Promo represents a promotional discount. Harga is the bundle price charged
+// when every product in ProductIDs is present on the same order.
 type Promo struct {
-	ID         uint           `gorm:"primaryKey"`
-	Nama       string         `gorm:"size:100;uniqueIndex"`
-	Harga      float64        `gorm:"not null;type:decimal(10,2)"`
-	ProductIDs []uint         `gorm:"-"` // Excluded from DB schema
-	DeletedAt  gorm.DeletedAt `gorm:"index"`
+	ID         uint            `gorm:"primaryKey"`
+	Nama       string          `gorm:"size:100;uniqueIndex"`
+	Harga      decimal.Decimal `gorm:"not null;type:decimal(10,2)"`
+	ProductIDs []uint          `gorm:"-"` // Stored in promo_products
+	DeletedAt  gorm.DeletedAt  `gorm:"index"`
+}
+
+// PromoProduct links a promo to the products that make up its bundle
+type PromoProduct struct {
+	PromoID   uint `gorm:"primaryKey;autoIncrement:false"`
+	ProductID uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// OrderDiscount is a promo applied to an order on the bill
+type OrderDiscount struct {
+	OrderID   uint            `json:"order_id"`
+	PromoID   uint            `json:"promo_id"`
+	PromoNama string          `json:"promo_nama"`
+	Count     int             `json:"count"`
+	Amount    decimal.Decimal `json:"amount"`
 }
 
 // Meja represents a table in the restaurant
 type Meja struct {
 	gorm.Model
-	ID   uint   `gorm:"primaryKey"`
-	Nama string `gorm:"size:50;uniqueIndex"` // Ensures unique Nama
-
+	ID       uint    `gorm:"primaryKey"`
+	Nama     string  `gorm:"size:50;uniqueIndex"` // Ensures unique Nama
+	Code     *string `gorm:"size:20;uniqueIndex"` // short code printed on the table, e.g. A1
+	Capacity int     `gorm:"not null;default:0"`  // seat count
+	Area     string  `gorm:"size:50;index"`       // zone such as Indoor, Outdoor or VIP
+	Status   string  `gorm:"size:20;not null;default:free"`
+
+	// Floor plan layout
+	Floor string `gorm:"size:50;index"`
+	PosX  int    `gorm:"not null;default:0"`
+	PosY  int    `gorm:"not null;default:0"`
+	Shape string `gorm:"size:20;not null;default:square"`
+
+	QRVersion int `gorm:"not null;default:1"` // bumped to invalidate the printed QR code
 }
 
 // CreateOrderRequest is used for creating a new order
 type CreateOrderRequest struct {
-	TableNumber int                `json:"table_number"`
+	TableNumber int                `json:"table_number"` // Meja ID
+	TableCode   string             `json:"table_code"`   // used when TableNumber is not set
 	Items       []OrderItemRequest `json:"items"`
 }
 
 // OrderItemRequest represents each item in the order request
 type OrderItemRequest struct {
-	ProductID uint `json:"product_id"`
-	Quantity  int  `json:"quantity"`
+	ProductID   uint   `json:"product_id"`
+	Quantity    int    `json:"quantity"`
+	Seat        int    `json:"seat"`
+	VariantID   *uint  `json:"variant_id"`
+	ModifierIDs []uint `json:"modifier_ids"`
 }
 
 type CreateOrderResponse struct {
@@ -114,44 +178,166 @@ func main() {
 	//loadEnv()
 	InitDatabase()
 	//InitRedis()
+	StartPrintWorker()
 	e := echo.New()
+	e.Use(middleware.RequestID())
+
+	//route api auth
+	e.POST("/api/v1/auth/login", LoginController)
+	//route api guest ordering, opened from the table QR code without an account
+	e.GET("/api/v1/guest/:token/menu", GetGuestMenuController)
+	e.POST("/api/v1/guest/:token/orders", CreateGuestOrderController)
+
+	// Every other route needs a signed-in staff member. Owners and managers
+	// pass every role check; hard deletes, voids and price edits are theirs only.
+	staff := e.Group("/api/v1", requireAuth)
+	floor := staff.Group("", requireRole(RoleCashier, RoleWaiter))
+	cashier := staff.Group("", requireRole(RoleCashier))
+	kitchen := staff.Group("", requireRole(RoleKitchen))
+	managers := staff.Group("", requireRole())
+
+	staff.GET("/auth/me", GetCurrentStaffController)
+	//route api staff accounts
+	managers.POST("/staff", CreateStaffController)
+	managers.GET("/staff", GetStaffController)
+	managers.PUT("/staff/:id", UpdateStaffController)
+	managers.DELETE("/staff/:id", DeleteStaffController)
+	//route api audit log
+	managers.GET("/audit", GetAuditLogsController)
+	//route api reports
+	managers.GET("/reports/daily", GetDailyReportController)
+	managers.GET("/reports/z", GetBusinessDaysController)
+	managers.GET("/reports/product-mix", GetProductMixController)
+	managers.POST("/reports/z-close", ZCloseController)
 
 	//route api Promo
-	e.POST("/api/v1/discount", AddPromoController)
-	e.GET("/api/v1/discount", GetPromoByIDController)
-	e.PUT("/api/v1/discount", UpdatePromoController)
-	e.DELETE("/api/v1/discount/:id", DeletePromoController)
+	managers.POST("/discount", AddPromoController)
+	staff.GET("/discount", GetPromosController)
+	staff.GET("/discount/:id", GetPromoByIDController)
+	managers.PUT("/discount/:id", UpdatePromoController)
+	managers.DELETE("/discount/:id", DeletePromoController)
+	managers.PUT("/discount/restore/:id", RestorePromoController)
 	//route api Meja
-	e.POST("/api/v1/table", AddMejaController)
-	e.GET("/api/v1/table", GetMejasController)
-	e.PUT("/api/v1/table/:id", UpdateMejaController)
-	e.DELETE("/api/v1/table/:id", SoftDeleteMejaController)
-	e.PUT("/api/v1/table/restore/:id", RestoreMejaController)
-	e.DELETE("/api/v1/table/hard-delete/:id", DeleteMejaController)
+	managers.POST("/table", AddMejaController)
+	staff.GET("/table", GetMejasController)
+	managers.PUT("/table/:id", UpdateMejaController)
+	managers.DELETE("/table/:id", SoftDeleteMejaController)
+	managers.PUT("/table/restore/:id", RestoreMejaController)
+	managers.DELETE("/table/hard-delete/:id", DeleteMejaController)
+	floor.PUT("/table/:id/status", SetMejaStatusController)
+	floor.POST("/table/transfer", TransferTableController)
+	floor.POST("/table/merge", MergeTablesController)
+	floor.POST("/table/unmerge", UnmergeTablesController)
+	staff.GET("/floorplan", GetFloorPlanController)
+	managers.GET("/table/:id/qr", GetTableQRController)
+	managers.POST("/table/:id/qr/rotate", RotateTableQRController)
+
+	//route api Category
+	managers.POST("/category", CreateCategoryController)
+	staff.GET("/category", GetCategoriesController)
+	managers.PUT("/category/reorder", ReorderCategoriesController)
+	managers.PUT("/category/:id", UpdateCategoryController)
+	managers.DELETE("/category/:id", SoftDeleteCategoryController)
+	managers.PUT("/category/restore/:id", RestoreCategoryController)
+	managers.DELETE("/category/hard-delete/:id", DeleteCategoryController)
 
 	//route api Printer
-	e.POST("/api/v1/printers", CreatePrinterController)
-	e.GET("api/v1/printers", GetPrintersController)
-	e.PUT("/api/v1/printers", UpdatePrinterController)
-	e.DELETE("/api/v1/printers/:id", SoftDeletePrinterController)
-	e.PUT("/api/v1/printers/:id/restore", RestorePrinterController)
-	e.DELETE("/api/v1/printers/hard-delete/:id", DeletePrinterController)
+	managers.POST("/printers", CreatePrinterController)
+	staff.GET("/printers", GetPrintersController)
+	managers.PUT("/printers", UpdatePrinterController)
+	managers.DELETE("/printers/:id", SoftDeletePrinterController)
+	managers.PUT("/printers/:id/restore", RestorePrinterController)
+	managers.DELETE("/printers/hard-delete/:id", DeletePrinterController)
+	managers.POST("/printers/:id/test", PrintTestPageController)
+	managers.POST("/printers/routes", CreatePrinterRouteController)
+	staff.GET("/printers/routes", GetPrinterRoutesController)
+	managers.PUT("/printers/routes/:id", UpdatePrinterRouteController)
+	managers.DELETE("/printers/routes/:id", DeletePrinterRouteController)
 	//post menu
-	e.POST("/api/v1/product", CreateProductController)
-	e.GET("api/v1/product", GetProductsController)
-	e.PUT("/api/v1/product", UpdateProductController)
-	e.DELETE("/api/v1/products/:id/soft-delete", SoftDeleteProductController)
-	e.PUT("/api/v1/product/:id/restore", RestoreProductController)
-	e.DELETE("/api/v1/product/hard-delete/:id", DeleteProductController)
+	managers.POST("/product", CreateProductController)
+	staff.GET("/product", GetProductsController)
+	managers.PUT("/product/:id", UpdateProductController)
+	managers.DELETE("/products/:id/soft-delete", SoftDeleteProductController)
+	managers.PUT("/product/:id/restore", RestoreProductController)
+	managers.DELETE("/product/hard-delete/:id", DeleteProductController)
+	managers.POST("/product/:id/variants", CreateProductVariantController)
+	staff.GET("/product/:id/variants", GetProductVariantsController)
+	managers.PUT("/product/variants/:id", UpdateProductVariantController)
+	managers.DELETE("/product/variants/:id", DeleteProductVariantController)
+	managers.GET("/product/:id/price-history", GetProductPriceHistoryController)
+	managers.PUT("/product/:id/modifier-groups", SetProductModifierGroupsController)
+	kitchen.PUT("/product/:id/availability", SetProductAvailabilityController)
+	staff.GET("/product/:id/schedules", GetProductSchedulesController)
+	managers.PUT("/product/:id/schedules", SetProductSchedulesController)
+	staff.GET("/product/:id/recipe", GetRecipeController)
+	managers.PUT("/product/:id/recipe", SetRecipeController)
+
+	//route api inventory
+	managers.POST("/ingredients", CreateIngredientController)
+	kitchen.GET("/ingredients", GetIngredientsController)
+	managers.PUT("/ingredients/:id", UpdateIngredientController)
+	managers.DELETE("/ingredients/:id", DeleteIngredientController)
+	kitchen.POST("/ingredients/:id/stock", AdjustStockController)
+	kitchen.GET("/ingredients/:id/movements", GetStockMovementsController)
+	kitchen.GET("/inventory/low-stock", GetLowStockController)
+
+	//route api modifier groups
+	managers.POST("/modifier-groups", CreateModifierGroupController)
+	staff.GET("/modifier-groups", GetModifierGroupsController)
+	managers.PUT("/modifier-groups/:id", UpdateModifierGroupController)
+	managers.DELETE("/modifier-groups/:id", DeleteModifierGroupController)
 	//post order
-	e.POST("/api/v1/neworder", CreateOrder)
-	e.GET("api/v1/neworder", GetOrderController)
-	e.PUT("/api/v1/neworder", UpdateOrderController)
-	e.DELETE("/api/v1/neworder/:id", SoftDeleteOrderController)
-	e.PUT("/api/v1/neworder/restore/:id", RestoreOrderController)
-	e.DELETE("/api/v1/neworder/hard-delete/:id", DeleteOrderController)
+	floor.POST("/neworder", CreateOrder)
+	staff.GET("/neworder", GetOrderController)
+	floor.PUT("/neworder/:id", UpdateOrderController)
+	staff.GET("/neworder/:id/history", GetOrderStatusHistoryController)
+	managers.DELETE("/neworder/:id", SoftDeleteOrderController)
+	managers.PUT("/neworder/restore/:id", RestoreOrderController)
+	managers.DELETE("/neworder/hard-delete/:id", DeleteOrderController)
+	floor.POST("/neworder/:id/reprint", ReprintOrderController)
+	floor.POST("/neworder/:id/items", AddOrderItemsController)
+	managers.POST("/neworder/:id/items/:item_id/void", VoidOrderItemController)
+	//route api table sessions
+	floor.POST("/table-sessions", OpenSessionController)
+	staff.GET("/table-sessions", GetSessionsController)
+	floor.GET("/table-sessions/:id/bill", GetSessionBillController)
+	cashier.POST("/table-sessions/:id/close", CloseSessionController)
+
+	//route api reservations and waitlist
+	floor.POST("/reservations", CreateReservationController)
+	floor.GET("/reservations", GetReservationsController)
+	floor.PUT("/reservations/:id", UpdateReservationController)
+	floor.POST("/reservations/:id/cancel", CancelReservationController)
+	floor.POST("/reservations/:id/no-show", NoShowReservationController)
+	floor.POST("/reservations/:id/seat", SeatReservationController)
+	floor.POST("/waitlist", JoinWaitlistController)
+	floor.GET("/waitlist", GetWaitlistController)
+	floor.POST("/waitlist/:id/seat", SeatWaitlistController)
+	floor.POST("/waitlist/:id/leave", LeaveWaitlistController)
+
+	//route api guest order approval
+	floor.GET("/neworder/pending", GetPendingOrdersController)
+	floor.POST("/neworder/:id/approve", ApproveOrderController)
+	floor.POST("/neworder/:id/reject", RejectOrderController)
+	staff.GET("/settings/guest-ordering", GetGuestOrderSettingController)
+	managers.PUT("/settings/guest-ordering", UpdateGuestOrderSettingController)
+
 	//route api Get bill
-	e.GET("/api/v1/bill/:table_number", GetBill)
+	floor.GET("/bill/:table_number", GetBill)
+	cashier.POST("/bill/:table_number/print", PrintBillController)
+	cashier.POST("/bill/:table_number/pay", PayBillController)
+	cashier.POST("/bill/:table_number/split", SplitBillController)
+	cashier.DELETE("/bill/:table_number/split", CancelSplitController)
+	//route api tax setting
+	staff.GET("/settings/tax", GetTaxSettingController)
+	managers.PUT("/settings/tax", UpdateTaxSettingController)
+	//route api kitchen display
+	kitchen.GET("/kds/station/:printer_id", GetStationTicketsController)
+	staff.GET("/kds/tickets/:id", GetTicketController)
+	kitchen.PUT("/kds/tickets/:id/status", UpdateTicketStatusController)
+	//route api print jobs
+	staff.GET("/print-jobs/failed", GetFailedPrintJobsController)
+	staff.POST("/print-jobs/:id/retry", RetryPrintJobController)
 	e.Start(":8000")
 }
 
@@ -188,19 +374,62 @@ func InitRedis() {
 	fmt.Println("Connected to Redis")
 }
 func Migration() {
+	migrateOrderStatus()
+	migrateCategories()
+	settlementsStored := DB.Migrator().HasTable(&BillSettlement{})
 	DB.AutoMigrate(
-	//&Promo{},
-	//&Printer{},
-	//&Meja{},
-	//&Product{},
-	//&Order{},
-	//&OrderItem{},
-	//&OrderItemRequest{},
-	//&OrderPrinter{},
-	//&CreateOrderRequest{},
-	//&CreateOrderResponse{},
-	//&OrderData{},
+		&PromoProduct{},
+		&KitchenTicket{},
+		&KitchenTicketItem{},
+		&PrinterRoute{},
+		&PrintJob{},
+		&Payment{},
+		&PaymentOrder{},
+		&BillSplit{},
+		&BillCheck{},
+		&BillCheckItem{},
+		&TaxSetting{},
+		&TableSession{},
+		&TableMerge{},
+		&Reservation{},
+		&GuestOrderSetting{},
+		&Staff{},
+		&AuditLog{},
+		&BusinessDay{},
+		&BillSettlement{},
+		&WaitlistEntry{},
+		&Order{},
+		&OrderStatusHistory{},
+		&OrderItemVoid{},
+		&OrderItem{},
+		&ProductPriceHistory{},
+		&Category{},
+		&Ingredient{},
+		&Recipe{},
+		&ProductSchedule{},
+		&StockMovement{},
+		&OrderItemModifier{},
+		&ProductVariant{},
+		&ModifierGroup{},
+		&Modifier{},
+		&Product{},
+		&Promo{},
+		&Printer{},
+		&Meja{},
+		//&OrderItemRequest{},
+		//&OrderPrinter{},
+		//&CreateOrderRequest{},
+		//&CreateOrderResponse{},
+		//&OrderData{},
 	)
+	migrateTableSessions()
+	backfillOrderItemSnapshots()
+	if !settlementsStored {
+		backfillBillSettlements()
+	}
+	syncMejaOccupancy()
+	seedPrinterRoutes()
+	seedOwner()
 
 }
 
@@ -219,7 +448,7 @@ func AddPromoController(c echo.Context) error {
 	}
 
 	// Validate promo data
-	if promo.Nama == "" || promo.Harga <= 0 {
+	if promo.Nama == "" || !promo.Harga.IsPositive() || len(promo.ProductIDs) == 0 {
 		return c.JSON(http.StatusBadRequest, BaseResponse{
 			Status:  false,
 			Message: "Invalid promo data",
@@ -239,9 +468,22 @@ func AddPromoController(c echo.Context) error {
 		})
 	}
 
-	// Create new promo
-	result := DB.Create(&promo)
-	if result.Error != nil {
+	if err := validatePromoProducts(DB, promo.ProductIDs); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, err.Error())
+	}
+
+	// Create new promo together with its product list
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&promo).Error; err != nil {
+			return err
+		}
+		if err := savePromoProducts(tx, promo.ID, promo.ProductIDs); err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditCreate, "promo", promo.ID, nil, promo)
+		return nil
+	})
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
 			Message: "Failed to create promo",
@@ -257,6 +499,23 @@ func AddPromoController(c echo.Context) error {
 }
 
 // read
+func GetPromosController(c echo.Context) error {
+	var promos []Promo
+
+	if err := DB.Find(&promos).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve promos")
+	}
+	if err := loadPromoProducts(DB, promos); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve promo products")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Promos retrieved successfully",
+		Data:    promos,
+	})
+}
+
 func GetPromoByIDController(c echo.Context) error {
 	id := c.Param("id")
 	var promo Promo
@@ -276,10 +535,15 @@ func GetPromoByIDController(c echo.Context) error {
 		})
 	}
 
+	promos := []Promo{promo}
+	if err := loadPromoProducts(DB, promos); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve promo products")
+	}
+
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
 		Message: "Promo retrieved successfully",
-		Data:    promo,
+		Data:    promos[0],
 	})
 }
 
@@ -296,7 +560,11 @@ func UpdatePromoController(c echo.Context) error {
 		})
 	}
 
-	var existingPromo Printer
+	if updatedPromo.Nama == "" || !updatedPromo.Harga.IsPositive() || len(updatedPromo.ProductIDs) == 0 {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid promo data")
+	}
+
+	var existingPromo Promo
 	if err := DB.First(&existingPromo, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return c.JSON(http.StatusNotFound, BaseResponse{
@@ -312,11 +580,27 @@ func UpdatePromoController(c echo.Context) error {
 		})
 	}
 
-	// Update fields
-	existingPromo.Name = updatedPromo.Nama
+	if err := validatePromoProducts(DB, updatedPromo.ProductIDs); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, err.Error())
+	}
 
-	result := DB.Save(&existingPromo)
-	if result.Error != nil {
+	// Update fields
+	before := auditSnapshot(existingPromo)
+	existingPromo.Nama = updatedPromo.Nama
+	existingPromo.Harga = updatedPromo.Harga
+	existingPromo.ProductIDs = updatedPromo.ProductIDs
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&existingPromo).Error; err != nil {
+			return err
+		}
+		if err := savePromoProducts(tx, existingPromo.ID, existingPromo.ProductIDs); err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "promo", existingPromo.ID, before, existingPromo)
+		return nil
+	})
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
 			Message: "Failed to update promo",
@@ -331,51 +615,13 @@ func UpdatePromoController(c echo.Context) error {
 	})
 }
 
-// softdelele
-func SoftDeletePromoController(c echo.Context) error {
-	// Extract category ID from request
-	id := c.Param("id")
-
-	// Find the category by ID
-	var promo Printer
-	if err := DB.First(&promo, id).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return c.JSON(http.StatusNotFound, BaseResponse{
-				Status:  false,
-				Message: "Promo not found",
-				Data:    nil,
-			})
-		}
-		return c.JSON(http.StatusInternalServerError, BaseResponse{
-			Status:  false,
-			Message: "Failed to find Promo",
-			Data:    nil,
-		})
-	}
-
-	// Perform soft delete
-	if err := DB.Delete(&promo).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, BaseResponse{
-			Status:  false,
-			Message: "Failed to delete category",
-			Data:    nil,
-		})
-	}
-
-	return c.JSON(http.StatusOK, BaseResponse{
-		Status:  true,
-		Message: "Successfully deleted category",
-		Data:    nil,
-	})
-}
-
 // restore
 func RestorePromoController(c echo.Context) error {
-	// Extract category ID from request
+	// Extract promo ID from request
 	id := c.Param("id")
 
-	// Find the category by ID (including soft-deleted records)
-	var promo Printer
+	// Find the promo by ID (including soft-deleted records)
+	var promo Promo
 	if err := DB.Unscoped().First(&promo, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return c.JSON(http.StatusNotFound, BaseResponse{
@@ -391,7 +637,7 @@ func RestorePromoController(c echo.Context) error {
 		})
 	}
 
-	// Check if the category is already active
+	// Check if the promo is already active
 	if promo.DeletedAt.Time.IsZero() {
 		return c.JSON(http.StatusConflict, BaseResponse{
 			Status:  false,
@@ -400,18 +646,29 @@ func RestorePromoController(c echo.Context) error {
 		})
 	}
 
-	// Restore the category by setting DeletedAt to nil
-	if err := DB.Model(&promo).Update("DeletedAt", nil).Error; err != nil {
+	// Restore the promo by setting DeletedAt to nil
+	before := auditSnapshot(promo)
+	result := DB.Unscoped().Model(&promo).Where("deleted_at IS NOT NULL").Update("deleted_at", nil)
+	if result.Error != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
 			Message: "Failed to restore Promo",
 			Data:    nil,
 		})
 	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusConflict, BaseResponse{
+			Status:  false,
+			Message: "promo is not deleted",
+			Data:    nil,
+		})
+	}
+	promo.DeletedAt = gorm.DeletedAt{}
+	recordAudit(c, DB, AuditRestore, "promo", promo.ID, before, promo)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
-		Message: "Successfully restored category",
+		Message: "Successfully restored promo",
 		Data:    promo,
 	})
 }
@@ -419,9 +676,16 @@ func RestorePromoController(c echo.Context) error {
 // delete
 func DeletePromoController(c echo.Context) error {
 	id := c.Param("id")
-	result := DB.Delete(&Printer{}, id)
 
-	if result.Error != nil {
+	var promo Promo
+	if err := DB.First(&promo, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, BaseResponse{
+				Status:  false,
+				Message: "Promo not found",
+				Data:    nil,
+			})
+		}
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
 			Message: "Failed to delete promo",
@@ -429,13 +693,14 @@ func DeletePromoController(c echo.Context) error {
 		})
 	}
 
-	if result.RowsAffected == 0 {
-		return c.JSON(http.StatusNotFound, BaseResponse{
+	if err := DB.Delete(&promo).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
-			Message: "Promo not found",
+			Message: "Failed to delete promo",
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditSoftDelete, "promo", promo.ID, promo, nil)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -444,6 +709,73 @@ func DeletePromoController(c echo.Context) error {
 	})
 }
 
+// validatePromoProducts makes sure every product in a promo bundle exists
+func validatePromoProducts(db *gorm.DB, productIDs []uint) error {
+	var count int64
+	ids := uniqueIDs(productIDs)
+	if err := db.Model(&Product{}).Where("id IN ?", ids).Count(&count).Error; err != nil {
+		return err
+	}
+	if int(count) != len(ids) {
+		return errors.New("Promo contains unknown products")
+	}
+	return nil
+}
+
+// savePromoProducts replaces the product list stored for a promo
+func savePromoProducts(tx *gorm.DB, promoID uint, productIDs []uint) error {
+	if err := tx.Where("promo_id = ?", promoID).Delete(&PromoProduct{}).Error; err != nil {
+		return err
+	}
+	ids := uniqueIDs(productIDs)
+	if len(ids) == 0 {
+		return nil
+	}
+	rows := make([]PromoProduct, 0, len(ids))
+	for _, productID := range ids {
+		rows = append(rows, PromoProduct{PromoID: promoID, ProductID: productID})
+	}
+	return tx.Create(&rows).Error
+}
+
+// loadPromoProducts fills ProductIDs for the given promos from promo_products
+func loadPromoProducts(db *gorm.DB, promos []Promo) error {
+	if len(promos) == 0 {
+		return nil
+	}
+	promoIDs := make([]uint, 0, len(promos))
+	for _, promo := range promos {
+		promoIDs = append(promoIDs, promo.ID)
+	}
+
+	var rows []PromoProduct
+	if err := db.Where("promo_id IN ?", promoIDs).Order("product_id").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	byPromo := make(map[uint][]uint)
+	for _, row := range rows {
+		byPromo[row.PromoID] = append(byPromo[row.PromoID], row.ProductID)
+	}
+	for i := range promos {
+		promos[i].ProductIDs = byPromo[promos[i].ID]
+	}
+	return nil
+}
+
+// uniqueIDs drops duplicate IDs while keeping their order
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool)
+	var result []uint
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
 // CreatePrinter creates a new printer record
 func CreatePrinterController(c echo.Context) error {
 	var printer Printer
@@ -457,9 +789,13 @@ func CreatePrinterController(c echo.Context) error {
 		})
 	}
 
+	if message := validatePrinterSettings(&printer); message != "" {
+		return createErrorResponse(c, http.StatusBadRequest, message)
+	}
+
 	// Check if printer with the same name already exists
 	var existingPrinter Printer
-	if err := DB.Where("nama = ?", printer.Name).First(&existingPrinter).Error; err == nil {
+	if err := DB.Where("name = ?", printer.Name).First(&existingPrinter).Error; err == nil {
 		return c.JSON(http.StatusConflict, BaseResponse{
 			Status:  false,
 			Message: "Printer with the same name already exists",
@@ -475,6 +811,7 @@ func CreatePrinterController(c echo.Context) error {
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditCreate, "printer", printer.ID, nil, printer)
 
 	return c.JSON(http.StatusCreated, BaseResponse{
 		Status:  true,
@@ -531,6 +868,17 @@ func UpdatePrinterController(c echo.Context) error {
 		})
 	}
 
+	if printer.PaperWidth == 0 {
+		printer.PaperWidth = existingPrinter.PaperWidth
+	}
+	if printer.CodePage == "" {
+		printer.CodePage = existingPrinter.CodePage
+	}
+	if message := validatePrinterSettings(&printer); message != "" {
+		return createErrorResponse(c, http.StatusBadRequest, message)
+	}
+
+	before := auditSnapshot(existingPrinter)
 	if result := DB.Model(&existingPrinter).Updates(printer).Error; result != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
@@ -538,6 +886,7 @@ func UpdatePrinterController(c echo.Context) error {
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditUpdate, "printer", existingPrinter.ID, before, existingPrinter)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -573,6 +922,7 @@ func SoftDeletePrinterController(c echo.Context) error {
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditSoftDelete, "printer", printer.ID, printer, nil)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -610,14 +960,25 @@ func RestorePrinterController(c echo.Context) error {
 		})
 	}
 
-	// Restore the printer by setting DeletedAt to zero value
-	if err := DB.Model(&printer).Update("DeletedAt", gorm.DeletedAt{}).Error; err != nil {
+	// Restore the printer by setting DeletedAt to nil
+	before := auditSnapshot(printer)
+	result := DB.Unscoped().Model(&printer).Where("deleted_at IS NOT NULL").Update("deleted_at", nil)
+	if result.Error != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
-			Message: "Failed to restore printer: " + err.Error(),
+			Message: "Failed to restore printer: " + result.Error.Error(),
+			Data:    nil,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusConflict, BaseResponse{
+			Status:  false,
+			Message: "Printer is not deleted",
 			Data:    nil,
 		})
 	}
+	printer.DeletedAt = gorm.DeletedAt{}
+	recordAudit(c, DB, AuditRestore, "printer", printer.ID, before, printer)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -655,6 +1016,7 @@ func DeletePrinterController(c echo.Context) error {
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditHardDelete, "printer", printer.ID, printer, nil)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -675,13 +1037,14 @@ func AddMejaController(c echo.Context) error {
 		})
 	}
 
-	if meja.Nama == "" {
+	if message := validateMeja(meja, 0); message != "" {
 		return c.JSON(http.StatusBadRequest, BaseResponse{
 			Status:  false,
-			Message: "Nama is required",
+			Message: message,
 			Data:    nil,
 		})
 	}
+	meja.Status = MejaFree
 
 	var existingMeja Meja
 	if err := DB.Where("nama = ?", meja.Nama).First(&existingMeja).Error; err == nil {
@@ -699,6 +1062,7 @@ func AddMejaController(c echo.Context) error {
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditCreate, "meja", meja.ID, nil, meja)
 
 	return c.JSON(http.StatusCreated, BaseResponse{
 		Status:  true,
@@ -735,14 +1099,6 @@ func UpdateMejaController(c echo.Context) error {
 		})
 	}
 
-	if updatedMeja.Nama == "" {
-		return c.JSON(http.StatusBadRequest, BaseResponse{
-			Status:  false,
-			Message: "Nama is required",
-			Data:    nil,
-		})
-	}
-
 	var existingMeja Meja
 	if err := DB.First(&existingMeja, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -759,7 +1115,25 @@ func UpdateMejaController(c echo.Context) error {
 		})
 	}
 
+	if message := validateMeja(updatedMeja, existingMeja.ID); message != "" {
+		return c.JSON(http.StatusBadRequest, BaseResponse{
+			Status:  false,
+			Message: message,
+			Data:    nil,
+		})
+	}
+
+	before := auditSnapshot(existingMeja)
 	existingMeja.Nama = updatedMeja.Nama
+	existingMeja.Code = updatedMeja.Code
+	existingMeja.Capacity = updatedMeja.Capacity
+	existingMeja.Area = updatedMeja.Area
+	existingMeja.Floor = updatedMeja.Floor
+	existingMeja.PosX = updatedMeja.PosX
+	existingMeja.PosY = updatedMeja.PosY
+	if updatedMeja.Shape != "" {
+		existingMeja.Shape = updatedMeja.Shape
+	}
 	if err := DB.Save(&existingMeja).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
@@ -767,6 +1141,7 @@ func UpdateMejaController(c echo.Context) error {
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditUpdate, "meja", existingMeja.ID, before, existingMeja)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -793,6 +1168,14 @@ func SoftDeleteMejaController(c echo.Context) error {
 		})
 	}
 
+	if meja.Status == MejaOccupied {
+		return c.JSON(http.StatusConflict, BaseResponse{
+			Status:  false,
+			Message: "Meja is occupied and cannot be deleted",
+			Data:    nil,
+		})
+	}
+
 	if err := DB.Delete(&meja).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
@@ -800,6 +1183,7 @@ func SoftDeleteMejaController(c echo.Context) error {
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditSoftDelete, "meja", meja.ID, meja, nil)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -834,13 +1218,24 @@ func RestoreMejaController(c echo.Context) error {
 		})
 	}
 
-	if err := DB.Model(&meja).Update("DeletedAt", nil).Error; err != nil {
+	before := auditSnapshot(meja)
+	result := DB.Unscoped().Model(&meja).Where("deleted_at IS NOT NULL").Update("deleted_at", nil)
+	if result.Error != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
 			Message: "Failed to restore meja",
 			Data:    nil,
 		})
 	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusConflict, BaseResponse{
+			Status:  false,
+			Message: "Meja is not deleted",
+			Data:    nil,
+		})
+	}
+	meja.DeletedAt = gorm.DeletedAt{}
+	recordAudit(c, DB, AuditRestore, "meja", meja.ID, before, meja)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -851,7 +1246,8 @@ func RestoreMejaController(c echo.Context) error {
 func DeleteMejaController(c echo.Context) error {
 	id := c.Param("id")
 
-	if err := DB.Unscoped().Delete(&Meja{}, id).Error; err != nil {
+	var meja Meja
+	if err := DB.Unscoped().First(&meja, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return c.JSON(http.StatusNotFound, BaseResponse{
 				Status:  false,
@@ -859,12 +1255,21 @@ func DeleteMejaController(c echo.Context) error {
 				Data:    nil,
 			})
 		}
+		return c.JSON(http.StatusInternalServerError, BaseResponse{
+			Status:  false,
+			Message: "Failed to find meja",
+			Data:    nil,
+		})
+	}
+
+	if err := DB.Unscoped().Delete(&meja).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
 			Message: "Failed to delete meja",
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditHardDelete, "meja", meja.ID, meja, nil)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -897,15 +1302,30 @@ func CreateProductController(c echo.Context) error {
 	//	})
 	//}
 
-	// Create new product
-	result := DB.Create(&product)
-	if result.Error != nil {
+	if message := validateProductCategory(product); message != "" {
+		return c.JSON(http.StatusBadRequest, BaseResponse{
+			Status:  false,
+			Message: "Invalid product data: " + message,
+			Data:    nil,
+		})
+	}
+	product.Category = nil
+
+	// Create new product and its first price
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+		return recordPriceChange(c, tx, product.ID, decimal.Zero, product.Price)
+	})
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
 			Message: "Failed to add product",
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditCreate, "product", product.ID, nil, product)
 
 	return c.JSON(http.StatusCreated, BaseResponse{
 		Status:  true,
@@ -916,7 +1336,9 @@ func CreateProductController(c echo.Context) error {
 func GetProductsController(c echo.Context) error {
 	var products []Product
 
-	result := DB.Find(&products)
+	result := DB.Preload("Category").
+		Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("sort_order, id") }).
+		Preload("ModifierGroups.Modifiers").Find(&products)
 
 	if result.Error != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
@@ -926,6 +1348,25 @@ func GetProductsController(c echo.Context) error {
 		})
 	}
 
+	if err := availableNow(DB, products, time.Now()); err != nil {
+		return c.JSON(http.StatusInternalServerError, BaseResponse{
+			Status:  false,
+			Message: "Failed to retrieve products: " + err.Error(),
+			Data:    nil,
+		})
+	}
+
+	// ?available=true lists only what can be ordered right now
+	if c.QueryParam("available") == "true" {
+		orderable := make([]Product, 0, len(products))
+		for _, product := range products {
+			if product.AvailableNow {
+				orderable = append(orderable, product)
+			}
+		}
+		products = orderable
+	}
+
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
 		Message: "Successfully retrieved products",
@@ -962,13 +1403,20 @@ func UpdateProductController(c echo.Context) error {
 			Data:    nil,
 		})
 	}
-	if updatedProduct.Price <= 0 {
+	if !updatedProduct.Price.IsPositive() {
 		return c.JSON(http.StatusBadRequest, BaseResponse{
 			Status:  false,
 			Message: "Invalid product data: Price must be greater than zero",
 			Data:    nil,
 		})
 	}
+	if message := validateProductCategory(updatedProduct); message != "" {
+		return c.JSON(http.StatusBadRequest, BaseResponse{
+			Status:  false,
+			Message: "Invalid product data: " + message,
+			Data:    nil,
+		})
+	}
 
 	// Find the existing product
 	var existingProduct Product
@@ -988,19 +1436,28 @@ func UpdateProductController(c echo.Context) error {
 	}
 
 	// Update product details
-	existingProduct.Category = updatedProduct.Category
+	before := auditSnapshot(existingProduct)
+	existingProduct.CategoryID = updatedProduct.CategoryID
 	existingProduct.Name = updatedProduct.Name
 	existingProduct.Varian = updatedProduct.Varian
+	oldPrice := existingProduct.Price
 	existingProduct.Price = updatedProduct.Price
 
-	// Save the updated product
-	if result := DB.Save(&existingProduct); result.Error != nil {
+	// Save the updated product and record a price change
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&existingProduct).Error; err != nil {
+			return err
+		}
+		return recordPriceChange(c, tx, existingProduct.ID, oldPrice, existingProduct.Price)
+	})
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
 			Message: "Failed to update product",
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditUpdate, "product", existingProduct.ID, before, existingProduct)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -1036,6 +1493,7 @@ func SoftDeleteProductController(c echo.Context) error {
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditSoftDelete, "product", product.ID, product, nil)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -1072,13 +1530,24 @@ func RestoreProductController(c echo.Context) error {
 		})
 	}
 
-	if err := DB.Model(&product).Update("DeletedAt", gorm.DeletedAt{}).Error; err != nil {
+	before := auditSnapshot(product)
+	result := DB.Unscoped().Model(&product).Where("deleted_at IS NOT NULL").Update("deleted_at", nil)
+	if result.Error != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
 			Message: "Failed to restore product",
 			Data:    nil,
 		})
 	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusConflict, BaseResponse{
+			Status:  false,
+			Message: "Product is not deleted",
+			Data:    nil,
+		})
+	}
+	product.DeletedAt = gorm.DeletedAt{}
+	recordAudit(c, DB, AuditRestore, "product", product.ID, before, product)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -1109,13 +1578,14 @@ func DeleteProductController(c echo.Context) error {
 	}
 
 	// Perform hard delete
-	if err := DB.Delete(&product).Error; err != nil {
+	if err := DB.Unscoped().Delete(&product).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, BaseResponse{
 			Status:  false,
 			Message: "Failed to delete product: " + err.Error(),
 			Data:    nil,
 		})
 	}
+	recordAudit(c, DB, AuditHardDelete, "product", product.ID, product, nil)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -1141,18 +1611,11 @@ func CreateOrder(c echo.Context) error {
 		}
 	}()
 
-	// Create the order
-	order := Order{
-		TableNumber: request.TableNumber,
-		Status:      0,
+	placed, status, message := placeOrder(tx, request, OrderSourceStaff, false)
+	if status != 0 {
+		return createErrorResponse(c, status, message)
 	}
-	if err := tx.Create(&order).Error; err != nil {
-		tx.Rollback()
-		return createErrorResponse(c, http.StatusInternalServerError, "Failed to create order")
-	}
-
-	// Process items and handle printers as before
-	responsePrinters, debugInfo := processOrderItems(tx, request.Items, order.ID)
+	recordAudit(c, tx, AuditCreate, "order", placed.Order.ID, nil, placed.Order)
 
 	if err := tx.Commit().Error; err != nil {
 		return createErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
@@ -1162,15 +1625,110 @@ func CreateOrder(c echo.Context) error {
 	return c.JSON(http.StatusCreated, BaseResponse{
 		Status:  true,
 		Message: "Order created successfully",
-		Data: map[string]interface{}{
-			"order_id":     order.ID,
-			"table_number": order.TableNumber,
-			"printers":     responsePrinters,
-			"debug_info":   debugInfo,
-		},
+		Data:    placed.response(),
 	})
 }
 
+// placedOrder is an order created by placeOrder with the printers its
+// items were sent to
+type placedOrder struct {
+	Order     Order
+	Session   TableSession
+	Printers  map[string][]string
+	DebugInfo []string
+}
+
+// response is the order summary returned to the caller
+func (p placedOrder) response() map[string]interface{} {
+	return map[string]interface{}{
+		"order_id":     p.Order.ID,
+		"table_number": p.Order.TableNumber,
+		"session_id":   p.Session.ID,
+		"status":       p.Order.Status,
+		"printers":     p.Printers,
+		"debug_info":   p.DebugInfo,
+	}
+}
+
+// placeOrder creates an order with its items in the session of its table.
+// Unless the order is held for approval, its items go to the kitchen
+// printers straight away. On failure the transaction is rolled back and
+// the response status and message are returned.
+func placeOrder(tx *gorm.DB, request CreateOrderRequest, source string, hold bool) (placedOrder, int, string) {
+	var placed placedOrder
+
+	// Resolve the table before anything is written
+	meja, err := resolveMeja(tx, request.TableNumber, request.TableCode)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, errUnknownTable) {
+			return placed, http.StatusBadRequest, err.Error()
+		}
+		return placed, http.StatusInternalServerError, "Failed to find table"
+	}
+	request.TableNumber = int(meja.ID)
+
+	// Attach the order to the table's session, seating walk-ins. Guests
+	// can only order at a table staff have seated.
+	if source == OrderSourceGuest {
+		placed.Session, err = activeSession(tx, request.TableNumber)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			return placed, http.StatusConflict, "This table has not been seated yet; please ask our staff"
+		}
+	} else {
+		placed.Session, err = ensureSession(tx, request.TableNumber)
+	}
+	if err != nil {
+		tx.Rollback()
+		return placed, http.StatusInternalServerError, "Failed to open table session"
+	}
+
+	// Create the order
+	status := OrderStatusOpen
+	if hold {
+		status = OrderStatusPendingApproval
+	}
+	placed.Order = Order{
+		TableNumber: request.TableNumber,
+		SessionID:   &placed.Session.ID,
+		Status:      status,
+		Source:      source,
+	}
+	if err := tx.Create(&placed.Order).Error; err != nil {
+		tx.Rollback()
+		return placed, http.StatusInternalServerError, "Failed to create order"
+	}
+	if err := recordOrderStatus(tx, placed.Order.ID, "", status, ""); err != nil {
+		tx.Rollback()
+		return placed, http.StatusInternalServerError, "Failed to create order"
+	}
+
+	// Held orders keep their items away from the printers until approved
+	if hold {
+		if items, rejected := createOrderItems(tx, request.Items, placed.Order.ID); items == nil {
+			return placed, http.StatusBadRequest, strings.Join(rejected, "; ")
+		}
+		placed.Printers = map[string][]string{}
+		return placed, 0, ""
+	}
+
+	// Process items and handle printers as before
+	placed.Printers, placed.DebugInfo = processOrderItems(tx, request.Items, placed.Order.ID)
+	if placed.Printers == nil {
+		return placed, http.StatusBadRequest, strings.Join(placed.DebugInfo, "; ")
+	}
+
+	// Orders with items routed to a station go straight to the kitchen
+	if len(placed.Printers) > 0 {
+		if err := transitionOrder(tx, &placed.Order, OrderStatusSentToKitchen, ""); err != nil {
+			tx.Rollback()
+			return placed, http.StatusInternalServerError, "Failed to update order status"
+		}
+	}
+	return placed, 0, ""
+}
+
 func GetOrderController(c echo.Context) error {
 	id := c.Param("id")
 	var order Order
@@ -1204,11 +1762,59 @@ func UpdateOrderController(c echo.Context) error {
 		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve order")
 	}
 
-	// Update order fields
-	order.TableNumber = request.TableNumber
-	order.Status = request.Status
+	if message, err := closedDayMessage(DB, order); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to check business day")
+	} else if message != "" {
+		return createErrorResponse(c, http.StatusConflict, message)
+	}
+
+	if order.Status == OrderStatusPendingApproval {
+		return createErrorResponse(c, http.StatusConflict, "Order is waiting for approval; approve or reject it first")
+	}
 
-	if err := DB.Save(&order).Error; err != nil {
+	if request.Status != "" {
+		if _, known := orderTransitions[request.Status]; !known {
+			return createErrorResponse(c, http.StatusBadRequest, "Unknown order status "+request.Status)
+		}
+	}
+
+	before := auditSnapshot(order)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		// Update order fields
+		if request.TableNumber != 0 && request.TableNumber != order.TableNumber {
+			if _, err := resolveMeja(tx, request.TableNumber, ""); err != nil {
+				return err
+			}
+
+			// The order moves to the session at its new table
+			session, err := ensureSession(tx, request.TableNumber)
+			if err != nil {
+				return err
+			}
+			order.TableNumber = request.TableNumber
+			order.SessionID = &session.ID
+			if err := tx.Model(&order).Updates(map[string]interface{}{
+				"table_number": order.TableNumber,
+				"session_id":   session.ID,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		if request.Status != "" {
+			if err := transitionOrder(tx, &order, request.Status, request.Note); err != nil {
+				return err
+			}
+		}
+		recordAudit(c, tx, AuditUpdate, "order", order.ID, before, order)
+		return nil
+	})
+	if errors.Is(err, ErrInvalidTransition) {
+		return createErrorResponse(c, http.StatusConflict, "Order cannot move from "+order.Status+" to "+request.Status)
+	}
+	if errors.Is(err, errUnknownTable) {
+		return createErrorResponse(c, http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
 		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update order")
 	}
 
@@ -1228,10 +1834,16 @@ func SoftDeleteOrderController(c echo.Context) error {
 		}
 		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find order")
 	}
+	if message, err := closedDayMessage(DB, order); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to check business day")
+	} else if message != "" {
+		return createErrorResponse(c, http.StatusConflict, message)
+	}
 
-	if err := DB.Model(&order).Update("DeletedAt", gorm.DeletedAt{}).Error; err != nil {
+	if err := DB.Delete(&order).Error; err != nil {
 		return createErrorResponse(c, http.StatusInternalServerError, "Failed to delete order")
 	}
+	recordAudit(c, DB, AuditSoftDelete, "order", order.ID, order, nil)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -1253,10 +1865,22 @@ func RestoreOrderController(c echo.Context) error {
 	if order.DeletedAt.Time.IsZero() {
 		return createErrorResponse(c, http.StatusConflict, "Order is not deleted")
 	}
+	if message, err := closedDayMessage(DB, order); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to check business day")
+	} else if message != "" {
+		return createErrorResponse(c, http.StatusConflict, message)
+	}
 
-	if err := DB.Model(&order).Update("DeletedAt", nil).Error; err != nil {
+	before := auditSnapshot(order)
+	result := DB.Unscoped().Model(&order).Where("deleted_at IS NOT NULL").Update("deleted_at", nil)
+	if result.Error != nil {
 		return createErrorResponse(c, http.StatusInternalServerError, "Failed to restore order")
 	}
+	if result.RowsAffected == 0 {
+		return createErrorResponse(c, http.StatusConflict, "Order is not deleted")
+	}
+	order.DeletedAt = gorm.DeletedAt{}
+	recordAudit(c, DB, AuditRestore, "order", order.ID, before, order)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -1267,12 +1891,23 @@ func RestoreOrderController(c echo.Context) error {
 
 func DeleteOrderController(c echo.Context) error {
 	id := c.Param("id")
-	if err := DB.Unscoped().Delete(&Order{}, id).Error; err != nil {
+	var order Order
+	if err := DB.Unscoped().Preload("Items").First(&order, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return createErrorResponse(c, http.StatusNotFound, "Order not found")
 		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find order")
+	}
+	if message, err := closedDayMessage(DB, order); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to check business day")
+	} else if message != "" {
+		return createErrorResponse(c, http.StatusConflict, message)
+	}
+
+	if err := DB.Unscoped().Delete(&order).Error; err != nil {
 		return createErrorResponse(c, http.StatusInternalServerError, "Failed to delete order")
 	}
+	recordAudit(c, DB, AuditHardDelete, "order", order.ID, order, nil)
 
 	return c.JSON(http.StatusOK, BaseResponse{
 		Status:  true,
@@ -1292,78 +1927,172 @@ func createErrorResponse(c echo.Context, statusCode int, message string) error {
 
 // Function to process order items and handle printers
 func processOrderItems(tx *gorm.DB, items []OrderItemRequest, orderID uint) (map[string][]string, []string) {
-	// Map categories to printer names
-	printerMap := map[string]string{
-		"Minuman": "Printer Bar",
-		"Makanan": "Printer Dapur",
+	orderItems, rejected := createOrderItems(tx, items, orderID)
+	if orderItems == nil {
+		return nil, rejected
 	}
+	return routeOrderItems(tx, orderID, orderItems)
+}
 
-	// Fetch all printers once
-	var allPrinters []Printer
-	if err := tx.Find(&allPrinters).Error; err != nil {
-		tx.Rollback()
-		log.Printf("Failed to find printers: %v", err)
-		return nil, []string{"Failed to find printers"}
-	}
+// createOrderItems checks and stores the items of an order and takes their
+// ingredients out of stock. It returns nil and the reasons when any item
+// cannot be ordered, after rolling back the transaction.
+func createOrderItems(tx *gorm.DB, items []OrderItemRequest, orderID uint) ([]OrderItem, []string) {
+	orderItems := make([]OrderItem, 0, len(items))
 
-	// Create a map of printer names to IDs
-	printerIDs := make(map[string][]string)
-	for _, printer := range allPrinters {
-		if printer.Name != "" { // Ensure the printer has a valid name
-			printerIDs[printer.Name] = append(printerIDs[printer.Name], printer.ID)
+	// Items that cannot be ordered; the order is refused if any
+	var rejected []string
+	now := time.Now()
+
+	for _, itemRequest := range items {
+		if itemRequest.Quantity < 1 {
+			tx.Rollback()
+			return nil, []string{"Quantity must be at least 1"}
 		}
 	}
 
-	// Initialize response data and debug information
-	responsePrinters := make(map[string][]string)
-	var debugInfo []string
-
 	for _, itemRequest := range items {
 		var product Product
-		if err := tx.Where("id = ?", itemRequest.ProductID).First(&product).Error; err != nil {
+		if err := tx.Preload("Category").Where("id = ?", itemRequest.ProductID).First(&product).Error; err != nil {
 			tx.Rollback()
 			return nil, []string{"Product not found"}
 		}
 
+		reason, err := productUnavailableReason(tx, product, now)
+		if err != nil {
+			tx.Rollback()
+			return nil, []string{"Failed to check product availability"}
+		}
+		if reason != "" {
+			rejected = append(rejected, reason)
+			continue
+		}
+
+		variant, modifiers, err := resolveItemOptions(tx, product, itemRequest)
+		if err != nil {
+			tx.Rollback()
+			return nil, []string{err.Error()}
+		}
+
 		orderItem := OrderItem{
 			OrderID:   orderID,
 			ProductID: product.ID,
 			Quantity:  itemRequest.Quantity,
+			Seat:      itemRequest.Seat,
+			Modifiers: modifiers,
+		}
+		if variant != nil {
+			orderItem.VariantID = &variant.ID
 		}
+		snapshotOrderItem(&orderItem, product, variant)
 		if err := tx.Create(&orderItem).Error; err != nil {
 			tx.Rollback()
 			return nil, []string{"Failed to create order item"}
 		}
 
-		printerName, exists := printerMap[product.Category]
-		if exists {
-			ids, found := printerIDs[printerName]
+		// Take the recipe ingredients out of stock
+		if err := deductStock(tx, orderItem, product); err != nil {
+			if errors.Is(err, errOutOfStock) {
+				rejected = append(rejected, err.Error())
+				continue
+			}
+			tx.Rollback()
+			log.Printf("Failed to deduct stock: %v", err)
+			return nil, []string{"Failed to deduct stock"}
+		}
+
+		orderItem.Product = product
+		orderItems = append(orderItems, orderItem)
+	}
+
+	if len(rejected) > 0 {
+		tx.Rollback()
+		return nil, rejected
+	}
+	return orderItems, nil
+}
+
+// routeOrderItems assigns order items to their printers, adds them to the
+// kitchen tickets and queues the tickets for printing. Items must have their
+// product loaded.
+func routeOrderItems(tx *gorm.DB, orderID uint, orderItems []OrderItem) (map[string][]string, []string) {
+	// Load the category/product to printer routing rules
+	router, err := loadPrinterRouter(tx)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Failed to load printer routes: %v", err)
+		return nil, []string{"Failed to load printer routes"}
+	}
+
+	// Fetch all printers once
+	var allPrinters []Printer
+	if err := tx.Find(&allPrinters).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Failed to find printers: %v", err)
+		return nil, []string{"Failed to find printers"}
+	}
+
+	// Create a map of printer IDs to names
+	printerNames := make(map[string]string)
+	for _, printer := range allPrinters {
+		printerNames[printer.ID] = printer.Name
+	}
+
+	// Initialize response data and debug information
+	responsePrinters := make(map[string][]string)
+	var debugInfo []string
+
+	// One kitchen ticket per station for this order
+	tickets := make(map[string]*KitchenTicket)
+
+	for _, orderItem := range orderItems {
+		product := orderItem.Product
+		ids, fallback := router.resolve(product)
+		if len(ids) == 0 {
+			debugInfo = append(debugInfo, fmt.Sprintf("No printer route found for product %s (category %s)", product.Name, categoryName(product)))
+			log.Printf("No printer route found for product %d (category %s)", product.ID, categoryName(product))
+			continue
+		}
+		if fallback {
+			debugInfo = append(debugInfo, fmt.Sprintf("Product %s sent to fallback printer", product.Name))
+		}
+
+		for _, printerID := range ids {
+			printerName, found := printerNames[printerID]
 			if !found {
-				debugInfo = append(debugInfo, fmt.Sprintf("No printers found for category %s", printerName))
-				log.Printf("No printers found for category %s", printerName)
+				debugInfo = append(debugInfo, fmt.Sprintf("Printer %s not found", printerID))
+				log.Printf("Printer %s not found", printerID)
 				continue
 			}
-			log.Printf("Found printers for category %s: %+v", printerName, ids)
-			if _, ok := responsePrinters[printerName]; !ok {
-				responsePrinters[printerName] = ids
+			responsePrinters[printerName] = appendUnique(responsePrinters[printerName], printerID)
+
+			orderPrinter := OrderPrinter{
+				OrderID:   orderID,
+				PrinterID: printerID,
+			}
+			if err := tx.Create(&orderPrinter).Error; err != nil {
+				tx.Rollback()
+				return nil, []string{"Failed to assign printer"}
 			}
-			for _, printerID := range ids {
-				orderPrinter := OrderPrinter{
-					OrderID:   orderID,
-					PrinterID: printerID,
-				}
-				if err := tx.Create(&orderPrinter).Error; err != nil {
-					tx.Rollback()
-					return nil, []string{"Failed to assign printer"}
-				}
-				log.Printf("Assigned printer %s to order %d", printerID, orderID)
+			if err := addToKitchenTicket(tx, tickets, orderID, printerID, orderItem.ID); err != nil {
+				tx.Rollback()
+				return nil, []string{"Failed to create kitchen ticket"}
 			}
-		} else {
-			debugInfo = append(debugInfo, fmt.Sprintf("No printer mapping found for product category %s", product.Category))
-			log.Printf("No printer mapping found for product category %s", product.Category)
+			log.Printf("Assigned printer %s to order %d", printerID, orderID)
 		}
 	}
 
+	// Queue the kitchen tickets for printing with the order
+	ticketIDs := make([]uint, 0, len(tickets))
+	for _, ticket := range tickets {
+		ticketIDs = append(ticketIDs, ticket.ID)
+	}
+	if err := enqueueTicketPrintJobs(tx, ticketIDs); err != nil {
+		tx.Rollback()
+		log.Printf("Failed to queue print jobs: %v", err)
+		return nil, []string{"Failed to queue print jobs"}
+	}
+
 	return responsePrinters, debugInfo
 }
 
@@ -1389,80 +2118,122 @@ func GetMejaController(c echo.Context) error {
 func GetBill(c echo.Context) error {
 	tableNumber := c.Param("table_number")
 
-	// Define a variable to store the EXPLAIN result
-	var explainResult []map[string]interface{}
-
-	// Construct the EXPLAIN query with the correct table and column names
-	explainQuery := `EXPLAIN SELECT orders.id, orders.table_number, order_items.product_id, products.name 
-                     FROM orders 
-                     JOIN order_items ON order_items.order_id = orders.id 
-                     JOIN products ON products.id = order_items.product_id 
-                     WHERE orders.table_number = ? AND orders.deleted_at IS NULL`
-
-	// Execute the EXPLAIN query
-	if err := DB.Raw(explainQuery, tableNumber).Scan(&explainResult).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve EXPLAIN result")
-	}
-
-	// Output the EXPLAIN result for debugging (or handle it as needed)
-	fmt.Println("EXPLAIN result:", explainResult)
-
-	// Retrieve the orders
-	var orders []Order
-	if err := DB.Preload("Items.Product").
-		Where("table_number = ? AND deleted_at IS NULL", tableNumber).
-		Find(&orders).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve orders")
+	number, err := strconv.Atoi(tableNumber)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid table number")
 	}
 
-	// Calculate the total amount
-	totalAmount := calculateTotalAmount(orders)
-
-	// Prepare the response
-	response := struct {
-		Orders      []Order `json:"orders"`
-		TotalAmount float64 `json:"total_amount"`
-	}{
-		Orders:      orders,
-		TotalAmount: totalAmount,
+	bill, err := buildBill(DB, number)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to calculate bill")
 	}
 
 	// Return the JSON response
-	return c.JSON(http.StatusOK, response)
+	return c.JSON(http.StatusOK, bill)
 }
 
 // calculateTotalAmount calculates the total amount of the given orders
-func calculateTotalAmount(orders []Order) float64 {
-	totalAmount := 0.0
+func calculateTotalAmount(orders []Order) decimal.Decimal {
+	totalAmount := decimal.Zero
 	for _, order := range orders {
 		for _, item := range order.Items {
-			totalAmount += float64(item.Quantity) * item.Product.Price
+			totalAmount = totalAmount.Add(itemLineTotal(item))
 		}
 	}
 	return totalAmount
 }
 
 // CalculateTotal calculates the total amount for a specific order ID including discounts
-func CalculateTotal(orderID uint, db *gorm.DB) (float64, error) {
+func CalculateTotal(orderID uint, db *gorm.DB) (decimal.Decimal, error) {
 	var order Order
-	if err := db.Preload("Items.Product").First(&order, orderID).Error; err != nil {
-		return 0, err
+	if err := db.Preload("Items", "voided_at IS NULL").Preload("Items.Product").Preload("Items.Variant").Preload("Items.Modifiers").
+		First(&order, orderID).Error; err != nil {
+		return decimal.Zero, err
 	}
 
 	total := calculateTotalAmount([]Order{order})
 
+	promos, err := activePromos(db)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	for _, discount := range applyPromos(order, promos) {
+		total = total.Sub(discount.Amount)
+	}
+
+	return total, nil
+}
+
+// activePromos loads every non-deleted promo together with its product list
+func activePromos(db *gorm.DB) ([]Promo, error) {
 	var promos []Promo
 	if err := db.Find(&promos).Error; err != nil {
-		return 0, err
+		return nil, err
+	}
+	if err := loadPromoProducts(db, promos); err != nil {
+		return nil, err
+	}
+	return promos, nil
+}
+
+// applyPromos matches promo bundles against the items of an order. A bundle
+// can be applied as many times as all of its products are available, and each
+// item quantity is only consumed by one bundle. Promos with the biggest saving
+// per bundle are applied first.
+func applyPromos(order Order, promos []Promo) []OrderDiscount {
+	remaining := make(map[uint]int)
+	prices := make(map[uint]decimal.Decimal)
+	for _, item := range order.Items {
+		remaining[item.ProductID] += item.Quantity
+		prices[item.ProductID] = itemProductPrice(item)
 	}
 
+	type candidate struct {
+		promo  Promo
+		saving decimal.Decimal
+	}
+	var candidates []candidate
 	for _, promo := range promos {
-		if containsAllProducts(order.Items, promo.ProductIDs) {
-			total -= promo.Harga
+		if len(promo.ProductIDs) == 0 || !containsAllProducts(order.Items, promo.ProductIDs) {
+			continue
+		}
+		normalPrice := decimal.Zero
+		for _, productID := range promo.ProductIDs {
+			normalPrice = normalPrice.Add(prices[productID])
+		}
+		if saving := normalPrice.Sub(promo.Harga); saving.IsPositive() {
+			candidates = append(candidates, candidate{promo: promo, saving: saving})
 		}
 	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].saving.GreaterThan(candidates[j].saving)
+	})
 
-	return total, nil
+	var discounts []OrderDiscount
+	for _, cand := range candidates {
+		count := -1
+		for _, productID := range cand.promo.ProductIDs {
+			if count == -1 || remaining[productID] < count {
+				count = remaining[productID]
+			}
+		}
+		if count <= 0 {
+			continue
+		}
+		for _, productID := range cand.promo.ProductIDs {
+			remaining[productID] -= count
+		}
+		discounts = append(discounts, OrderDiscount{
+			OrderID:   order.ID,
+			PromoID:   cand.promo.ID,
+			PromoNama: cand.promo.Nama,
+			Count:     count,
+			Amount:    cand.saving.Mul(decimal.NewFromInt(int64(count))),
+		})
+	}
+
+	return discounts
 }
 
 // containsAllProducts checks if all specified product IDs are present in the order items
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrderItemVoid records items taken off an order after it was placed
+type OrderItemVoid struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	OrderID     uint      `gorm:"not null;index" json:"order_id"`
+	OrderItemID uint      `gorm:"not null;index" json:"order_item_id"`
+	Quantity    int       `gorm:"not null" json:"quantity"`
+	Reason      string    `gorm:"size:255;not null" json:"reason"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+}
+
+// AddOrderItemsRequest adds another round of items to an order
+type AddOrderItemsRequest struct {
+	Items []OrderItemRequest `json:"items"`
+}
+
+// VoidOrderItemRequest voids some or all of an order item. A zero quantity
+// voids the whole item.
+type VoidOrderItemRequest struct {
+	Quantity int    `json:"quantity"`
+	Reason   string `json:"reason"`
+}
+
+// modifiableOrderStatuses are the statuses in which items can be added
+var modifiableOrderStatuses = []string{OrderStatusOpen, OrderStatusSentToKitchen, OrderStatusServed}
+
+var errOrderItemRejected = errors.New("order item change rejected")
+
+// lockOrder loads an order for update inside a transaction
+func lockOrder(tx *gorm.DB, id string) (Order, error) {
+	var order Order
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, id).Error
+	return order, err
+}
+
+// AddOrderItemsController appends items to an existing order. Only the new
+// items are routed to the printers.
+func AddOrderItemsController(c echo.Context) error {
+	id := c.Param("id")
+
+	var request AddOrderItemsRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	if len(request.Items) == 0 {
+		return createErrorResponse(c, http.StatusBadRequest, "No items to add")
+	}
+
+	var (
+		order            Order
+		responsePrinters map[string][]string
+		debugInfo        []string
+		status           = http.StatusInternalServerError
+		message          = "Failed to add items"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		order, err = lockOrder(tx, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status, message = http.StatusNotFound, "Order not found"
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		closed, err := closedDayMessage(tx, order)
+		if err != nil {
+			return err
+		}
+		if closed != "" {
+			status, message = http.StatusConflict, closed
+			return errOrderItemRejected
+		}
+		if !containsString(modifiableOrderStatuses, order.Status) {
+			status, message = http.StatusConflict, "Items cannot be added to a "+order.Status+" order"
+			return errOrderItemRejected
+		}
+
+		before := auditSnapshot(order)
+		responsePrinters, debugInfo = processOrderItems(tx, request.Items, order.ID)
+		if responsePrinters == nil {
+			status, message = http.StatusBadRequest, strings.Join(debugInfo, "; ")
+			return errOrderItemRejected
+		}
+
+		if len(responsePrinters) > 0 && order.Status != OrderStatusSentToKitchen {
+			if err := transitionOrder(tx, &order, OrderStatusSentToKitchen, "Items added"); err != nil {
+				return err
+			}
+		}
+		recordAudit(c, tx, AuditUpdate, "order", order.ID, before, map[string]interface{}{
+			"order":       order,
+			"added_items": request.Items,
+		})
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Items added successfully",
+		Data: map[string]interface{}{
+			"order_id":     order.ID,
+			"table_number": order.TableNumber,
+			"status":       order.Status,
+			"printers":     responsePrinters,
+			"debug_info":   debugInfo,
+		},
+	})
+}
+
+// VoidOrderItemController takes an item off an order with a reason and
+// prints a cancel ticket at every station that received it
+func VoidOrderItemController(c echo.Context) error {
+	id := c.Param("id")
+	itemID := c.Param("item_id")
+
+	var request VoidOrderItemRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" {
+		return createErrorResponse(c, http.StatusBadRequest, "A reason is required to void an item")
+	}
+	if request.Quantity < 0 {
+		return createErrorResponse(c, http.StatusBadRequest, "Quantity cannot be negative")
+	}
+
+	var (
+		order   Order
+		item    OrderItem
+		void    OrderItemVoid
+		status  = http.StatusInternalServerError
+		message = "Failed to void item"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		order, err = lockOrder(tx, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status, message = http.StatusNotFound, "Order not found"
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		closed, err := closedDayMessage(tx, order)
+		if err != nil {
+			return err
+		}
+		if closed != "" {
+			status, message = http.StatusConflict, closed
+			return errOrderItemRejected
+		}
+		if containsString(closedOrderStatuses, order.Status) {
+			status, message = http.StatusConflict, "Items cannot be voided on a "+order.Status+" order"
+			return errOrderItemRejected
+		}
+
+		if err := tx.Preload("Product").Preload("Variant").Preload("Modifiers").Where("id = ? AND order_id = ?", itemID, order.ID).First(&item).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "Order item not found"
+			}
+			return err
+		}
+		if item.VoidedAt != nil || item.Quantity == 0 {
+			status, message = http.StatusConflict, "Item is already voided"
+			return errOrderItemRejected
+		}
+
+		quantity := request.Quantity
+		if quantity == 0 {
+			quantity = item.Quantity
+		}
+		if quantity > item.Quantity {
+			status, message = http.StatusBadRequest, "Cannot void more than the ordered quantity"
+			return errOrderItemRejected
+		}
+
+		before := auditSnapshot(item)
+		void = OrderItemVoid{
+			OrderID:     order.ID,
+			OrderItemID: item.ID,
+			Quantity:    quantity,
+			Reason:      request.Reason,
+		}
+		if err := tx.Create(&void).Error; err != nil {
+			return err
+		}
+
+		item.Quantity -= quantity
+		updates := map[string]interface{}{"quantity": item.Quantity}
+		if item.Quantity == 0 {
+			now := time.Now()
+			item.VoidedAt = &now
+			updates["voided_at"] = now
+		}
+		if err := tx.Model(&item).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		// Put the voided units' ingredients back in stock
+		var voided int64
+		if err := tx.Model(&OrderItemVoid{}).Where("order_item_id = ?", item.ID).
+			Select("COALESCE(SUM(quantity), 0)").Scan(&voided).Error; err != nil {
+			return err
+		}
+		if err := restoreItemStock(tx, item, quantity, item.Quantity+int(voided)); err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "order_item", item.ID, before, map[string]interface{}{
+			"item": item,
+			"void": void,
+		})
+
+		if err := enqueueVoidPrintJobs(tx, order, item, quantity, request.Reason); err != nil {
+			return err
+		}
+
+		// An order with nothing left on it is cancelled
+		var remaining int64
+		if err := tx.Model(&OrderItem{}).Where("order_id = ? AND quantity > 0", order.ID).Count(&remaining).Error; err != nil {
+			return err
+		}
+		if remaining == 0 && canTransition(order.Status, OrderStatusCancelled) {
+			return transitionOrder(tx, &order, OrderStatusCancelled, "All items voided")
+		}
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Item voided successfully",
+		Data: map[string]interface{}{
+			"void":   void,
+			"item":   item,
+			"status": order.Status,
+		},
+	})
+}
+
+// enqueueVoidPrintJobs queues a cancel ticket for every station that has
+// the item on one of its kitchen tickets
+func enqueueVoidPrintJobs(tx *gorm.DB, order Order, item OrderItem, quantity int, reason string) error {
+	var tickets []KitchenTicket
+	if err := tx.Where("id IN (?)", tx.Model(&KitchenTicketItem{}).Select("kitchen_ticket_id").Where("order_item_id = ?", item.ID)).
+		Find(&tickets).Error; err != nil {
+		return err
+	}
+
+	for _, ticket := range tickets {
+		var printer Printer
+		if err := tx.First(&printer, "id = ?", ticket.PrinterID).Error; err != nil {
+			return err
+		}
+		if printer.Address == "" {
+			continue
+		}
+
+		payload, err := RenderVoidTicket(printer, order, item, quantity, reason)
+		if err != nil {
+			return err
+		}
+		ticketID := ticket.ID
+		if err := enqueuePrintJob(tx, order.ID, printer.ID, &ticketID, PrintKindVoid, payload); err != nil {
+			return err
+		}
+		log.Printf("Queued void ticket for item %d on printer %s", item.ID, printer.ID)
+	}
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, existing := range list {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Order statuses
+const (
+	OrderStatusOpen          = "open"
+	OrderStatusSentToKitchen = "sent_to_kitchen"
+	OrderStatusServed        = "served"
+	OrderStatusBilled        = "billed"
+	OrderStatusPaid          = "paid"
+	OrderStatusCancelled     = "cancelled"
+
+	// OrderStatusPendingApproval holds a guest order until staff approve it
+	OrderStatusPendingApproval = "pending_approval"
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Orders only move forward, except that a served order goes back to the
+// kitchen when another round is added; paid and cancelled orders are final.
+// Paid is missing on purpose: orders only get there when their bill is
+// settled, through settleOrders.
+var orderTransitions = map[string][]string{
+	OrderStatusPendingApproval: {OrderStatusOpen, OrderStatusSentToKitchen, OrderStatusCancelled},
+	OrderStatusOpen:            {OrderStatusSentToKitchen, OrderStatusServed, OrderStatusBilled, OrderStatusCancelled},
+	OrderStatusSentToKitchen:   {OrderStatusServed, OrderStatusBilled, OrderStatusCancelled},
+	OrderStatusServed:          {OrderStatusSentToKitchen, OrderStatusBilled, OrderStatusCancelled},
+	OrderStatusBilled:          {},
+	OrderStatusPaid:            {},
+	OrderStatusCancelled:       {},
+}
+
+// payableOrderStatuses are the statuses of orders a settled bill marks paid
+var payableOrderStatuses = []string{OrderStatusOpen, OrderStatusSentToKitchen, OrderStatusServed, OrderStatusBilled}
+
+// closedOrderStatuses are the statuses of orders that are no longer on a bill
+var closedOrderStatuses = []string{OrderStatusPaid, OrderStatusCancelled}
+
+// unbilledOrderStatuses are left off every bill on top of the excluded ones
+var unbilledOrderStatuses = []string{OrderStatusPendingApproval}
+
+// ErrInvalidTransition is returned when an order cannot move to a status
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// OrderStatusHistory records every status change of an order
+type OrderStatusHistory struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	OrderID    uint      `gorm:"not null;index" json:"order_id"`
+	FromStatus string    `gorm:"size:20" json:"from_status"`
+	ToStatus   string    `gorm:"size:20;not null" json:"to_status"`
+	Note       string    `gorm:"size:255" json:"note"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// canTransition reports whether an order may move from one status to another
+func canTransition(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// recordOrderStatus writes a status history row for an order
+func recordOrderStatus(tx *gorm.DB, orderID uint, from, to, note string) error {
+	return tx.Create(&OrderStatusHistory{
+		OrderID:    orderID,
+		FromStatus: from,
+		ToStatus:   to,
+		Note:       note,
+	}).Error
+}
+
+// transitionOrder moves an order to a new status and records the change. A
+// cancelled order has its ingredients put back in stock. It returns
+// ErrInvalidTransition when the move is not allowed.
+func transitionOrder(tx *gorm.DB, order *Order, to, note string) error {
+	if order.Status == to {
+		return nil
+	}
+	if !canTransition(order.Status, to) {
+		return ErrInvalidTransition
+	}
+
+	if err := setOrderStatus(tx, order, to, note); err != nil {
+		return err
+	}
+	if to == OrderStatusCancelled {
+		return restoreOrderStock(tx, order.ID)
+	}
+	return nil
+}
+
+// setOrderStatus writes a new status and records the change
+func setOrderStatus(tx *gorm.DB, order *Order, to, note string) error {
+	from := order.Status
+	if err := tx.Model(order).Update("status", to).Error; err != nil {
+		return err
+	}
+	order.Status = to
+	return recordOrderStatus(tx, order.ID, from, to, note)
+}
+
+// transitionOrders moves every order that is allowed to the new status and
+// leaves the others as they are
+func transitionOrders(tx *gorm.DB, orderIDs []uint, to, note string) error {
+	if len(orderIDs) == 0 {
+		return nil
+	}
+
+	var orders []Order
+	if err := tx.Where("id IN ?", orderIDs).Find(&orders).Error; err != nil {
+		return err
+	}
+	for i := range orders {
+		if !canTransition(orders[i].Status, to) {
+			continue
+		}
+		if err := transitionOrder(tx, &orders[i], to, note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settleOrders marks the orders of a settled bill paid. Orders that are
+// already paid, cancelled or waiting for approval are left as they are.
+func settleOrders(tx *gorm.DB, orderIDs []uint, note string) error {
+	if len(orderIDs) == 0 {
+		return nil
+	}
+
+	var orders []Order
+	if err := tx.Where("id IN ? AND status IN ?", orderIDs, payableOrderStatuses).Find(&orders).Error; err != nil {
+		return err
+	}
+	for i := range orders {
+		if err := setOrderStatus(tx, &orders[i], OrderStatusPaid, note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateOrderStatus converts the old integer status column (0 new, 1 paid)
+// to named statuses
+func migrateOrderStatus() {
+	if !DB.Migrator().HasTable(&Order{}) {
+		return
+	}
+	columnTypes, err := DB.Migrator().ColumnTypes(&Order{})
+	if err != nil {
+		return
+	}
+	for _, column := range columnTypes {
+		if column.Name() != "status" || strings.Contains(strings.ToLower(column.DatabaseTypeName()), "char") {
+			continue
+		}
+		DB.Exec("ALTER TABLE orders MODIFY status varchar(20)")
+		DB.Exec("UPDATE orders SET status = CASE WHEN status = '1' THEN ? ELSE ? END", OrderStatusPaid, OrderStatusOpen)
+	}
+}
+
+// GetOrderStatusHistoryController lists the status changes of an order
+func GetOrderStatusHistoryController(c echo.Context) error {
+	id := c.Param("id")
+
+	var order Order
+	if err := DB.Unscoped().First(&order, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Order not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve order")
+	}
+
+	var history []OrderStatusHistory
+	if err := DB.Where("order_id = ?", order.ID).Order("id").Find(&history).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve order history")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Order history retrieved successfully",
+		Data:    history,
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Payment methods
+const (
+	PaymentCash = "cash"
+	PaymentCard = "card"
+	PaymentQRIS = "qris"
+)
+
+// Payment is money received against the bill of a table. A bill may be
+// settled by several partial payments.
+type Payment struct {
+	gorm.Model
+	TableNumber int             `gorm:"not null;index" json:"table_number"`
+	Method      string          `gorm:"size:10;not null" json:"method"`
+	Amount      decimal.Decimal `gorm:"not null;type:decimal(10,2)" json:"amount"`   // applied to the bill
+	Tendered    decimal.Decimal `gorm:"not null;type:decimal(10,2)" json:"tendered"` // handed over by the guest
+	Change      decimal.Decimal `gorm:"not null;type:decimal(10,2)" json:"change"`
+	Reference   string          `gorm:"size:100" json:"reference"`  // card approval code or QRIS reference number
+	BillCheckID *uint           `gorm:"index" json:"bill_check_id"` // set when paying one check of a split bill
+}
+
+// errPaymentRejected aborts a payment transaction after the response
+// message has been chosen
+var errPaymentRejected = errors.New("payment rejected")
+
+// PaymentOrder links a payment to the orders of the bill it was made for
+type PaymentOrder struct {
+	PaymentID uint `gorm:"primaryKey;autoIncrement:false"`
+	OrderID   uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// PayBillRequest is a payment made at the cashier. Amount defaults to the
+// amount still due; for cash, Tendered is the money handed over. CheckID
+// pays one check of a split bill instead of the whole bill.
+type PayBillRequest struct {
+	Method    string          `json:"method"`
+	Amount    decimal.Decimal `json:"amount"`
+	Tendered  decimal.Decimal `json:"tendered"`
+	Reference string          `json:"reference"`
+	CheckID   uint            `json:"check_id"`
+}
+
+// loadBillPayments loads the payments made against the orders of a bill
+func loadBillPayments(db *gorm.DB, bill *Bill) error {
+	orderIDs := billOrderIDs(*bill)
+	if len(orderIDs) == 0 {
+		return nil
+	}
+	return db.Where("id IN (?)", db.Model(&PaymentOrder{}).Select("payment_id").Where("order_id IN ?", orderIDs)).
+		Order("created_at").Find(&bill.Payments).Error
+}
+
+// PayBillController records a payment for a table and marks its orders paid
+// once the bill is settled
+func PayBillController(c echo.Context) error {
+	tableNumber, err := strconv.Atoi(c.Param("table_number"))
+	if err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid table number")
+	}
+
+	var request PayBillRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	request.Method = strings.ToLower(request.Method)
+
+	var (
+		payment   Payment
+		bill      Bill
+		fullyPaid bool
+		status    = http.StatusInternalServerError
+		message   = "Failed to record payment"
+	)
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the table's session so two cashiers cannot settle the same bill
+		if _, err := lockedSession(tx, tableNumber); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var err error
+		bill, err = buildBill(tx, tableNumber)
+		if err != nil {
+			return err
+		}
+		if len(bill.Orders) == 0 || !bill.AmountDue.IsPositive() {
+			status, message = http.StatusConflict, "Bill has nothing to pay"
+			return errPaymentRejected
+		}
+
+		amountDue := bill.AmountDue
+		var check *BillCheck
+		if request.CheckID != 0 {
+			check = findBillCheck(bill, request.CheckID)
+			if check == nil {
+				status, message = http.StatusNotFound, "Check not found on this bill"
+				return errPaymentRejected
+			}
+			if bill.Split.Stale {
+				status, message = http.StatusConflict, "Orders changed after the bill was split; split it again"
+				return errPaymentRejected
+			}
+			if !check.AmountDue.IsPositive() {
+				status, message = http.StatusConflict, "Check is already paid"
+				return errPaymentRejected
+			}
+			amountDue = decimal.Min(check.AmountDue, amountDue)
+		}
+
+		payment, message = preparePayment(request, tableNumber, amountDue)
+		if message != "" {
+			status = http.StatusBadRequest
+			return errPaymentRejected
+		}
+		if check != nil {
+			checkID := check.ID
+			payment.BillCheckID = &checkID
+		}
+
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+		links := make([]PaymentOrder, 0, len(bill.Orders))
+		for _, orderID := range billOrderIDs(bill) {
+			links = append(links, PaymentOrder{PaymentID: payment.ID, OrderID: orderID})
+		}
+		if err := tx.Create(&links).Error; err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditCreate, "payment", payment.ID, nil, payment)
+
+		bill.Payments = append(bill.Payments, payment)
+		bill.PaidAmount = bill.PaidAmount.Add(payment.Amount)
+		bill.AmountDue = bill.AmountDue.Sub(payment.Amount)
+
+		// Settled orders drop off the next bill
+		if !bill.AmountDue.IsPositive() {
+			fullyPaid = true
+			if bill.Split != nil {
+				if err := tx.Model(bill.Split).Update("closed", true).Error; err != nil {
+					return err
+				}
+			}
+			if err := recordSettlement(tx, bill, payment.CreatedAt); err != nil {
+				return err
+			}
+			return settleOrders(tx, billOrderIDs(bill), "Bill settled")
+		}
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Payment recorded successfully",
+		Data: map[string]interface{}{
+			"payment":    payment,
+			"change":     payment.Change,
+			"amount_due": bill.AmountDue,
+			"fully_paid": fullyPaid,
+			"bill":       bill,
+		},
+	})
+}
+
+// findBillCheck returns a check of the bill's open split
+func findBillCheck(bill Bill, checkID uint) *BillCheck {
+	if bill.Split == nil {
+		return nil
+	}
+	for i := range bill.Split.Checks {
+		if bill.Split.Checks[i].ID == checkID {
+			return &bill.Split.Checks[i]
+		}
+	}
+	return nil
+}
+
+// preparePayment validates a payment request against the amount due and
+// works out the applied amount and change. It returns a message when the
+// payment is rejected.
+func preparePayment(request PayBillRequest, tableNumber int, amountDue decimal.Decimal) (Payment, string) {
+	payment := Payment{
+		TableNumber: tableNumber,
+		Method:      request.Method,
+		Amount:      roundMoney(request.Amount),
+		Tendered:    roundMoney(request.Tendered),
+		Reference:   strings.TrimSpace(request.Reference),
+	}
+
+	if payment.Amount.IsNegative() || payment.Tendered.IsNegative() {
+		return payment, "Amounts cannot be negative"
+	}
+
+	switch payment.Method {
+	case PaymentCash:
+		if payment.Amount.IsZero() {
+			// Without an amount, the cash handed over pays as much of the bill as it can
+			payment.Amount = amountDue
+			if payment.Tendered.IsPositive() && payment.Tendered.LessThan(amountDue) {
+				payment.Amount = payment.Tendered
+			}
+		}
+		if payment.Tendered.IsZero() {
+			payment.Tendered = payment.Amount
+		}
+		if payment.Tendered.LessThan(payment.Amount) {
+			return payment, "Tendered cash is less than the payment amount"
+		}
+	case PaymentCard, PaymentQRIS:
+		if payment.Amount.IsZero() {
+			payment.Amount = amountDue
+		}
+		if payment.Method == PaymentQRIS && payment.Reference == "" {
+			return payment, "QRIS payments need a reference number"
+		}
+		payment.Tendered = payment.Amount
+	default:
+		return payment, "Payment method must be cash, card or qris"
+	}
+
+	if payment.Amount.GreaterThan(amountDue) {
+		return payment, "Payment amount is more than the amount due"
+	}
+	payment.Change = payment.Tendered.Sub(payment.Amount)
+
+	return payment, ""
+}
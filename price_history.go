@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ProductPriceHistory records every price a product has had. Orders keep
+// the price of their own time on OrderItem, so this is for reporting only.
+type ProductPriceHistory struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	ProductID   uint            `gorm:"not null;index" json:"product_id"`
+	OldPrice    decimal.Decimal `gorm:"type:decimal(10,2)" json:"old_price"` // zero for a new product
+	Price       decimal.Decimal `gorm:"not null;type:decimal(10,2)" json:"price"`
+	ChangedByID *uint           `json:"changed_by_id"`
+	ChangedBy   string          `gorm:"size:100" json:"changed_by"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// recordPriceChange writes a price history row when a product price is set
+// or changed
+func recordPriceChange(c echo.Context, db *gorm.DB, productID uint, oldPrice, price decimal.Decimal) error {
+	if oldPrice.Equal(price) {
+		return nil
+	}
+	history := ProductPriceHistory{
+		ProductID: productID,
+		OldPrice:  oldPrice,
+		Price:     price,
+	}
+	if staff, ok := currentStaff(c); ok {
+		history.ChangedByID = &staff.ID
+		history.ChangedBy = staff.Username
+	}
+	return db.Create(&history).Error
+}
+
+// snapshotOrderItem copies the product name, price and variant onto an
+// order item so later menu edits do not change the order
+func snapshotOrderItem(item *OrderItem, product Product, variant *ProductVariant) {
+	item.ProductName = product.Name
+	item.ProductVarian = product.Varian
+	item.UnitPrice = product.Price
+	if variant != nil {
+		item.VariantName = variant.Name
+		item.VariantPriceDelta = variant.PriceDelta
+	}
+}
+
+// itemProduct is the product of an order item with the name and Varian it
+// had when ordered
+func itemProduct(item OrderItem) Product {
+	product := item.Product
+	if item.ProductName != "" {
+		product.Name = item.ProductName
+		product.Varian = item.ProductVarian
+	}
+	return product
+}
+
+// backfillOrderItemSnapshots fills in the snapshot of order items created
+// before items kept one. The price they were ordered at is lost, so they
+// take the current product and variant price.
+func backfillOrderItemSnapshots() {
+	var items []OrderItem
+	if err := DB.Preload("Product", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Preload("Variant", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Where("product_name = '' OR product_name IS NULL").Find(&items).Error; err != nil {
+		log.Printf("Failed to load order items to backfill: %v", err)
+		return
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, item := range items {
+			snapshotOrderItem(&item, item.Product, item.Variant)
+			if item.Product.ID == 0 {
+				// The product was deleted for good; name it so the item is
+				// not picked up again on the next start
+				item.ProductName = fmt.Sprintf("Deleted product #%d", item.ProductID)
+			}
+			if err := tx.Model(&OrderItem{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
+				"product_name":        item.ProductName,
+				"product_varian":      item.ProductVarian,
+				"unit_price":          item.UnitPrice,
+				"variant_name":        item.VariantName,
+				"variant_price_delta": item.VariantPriceDelta,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to backfill order item snapshots: %v", err)
+		return
+	}
+	log.Printf("Backfilled the price snapshot of %d order items", len(items))
+}
+
+// GetProductPriceHistoryController lists the price changes of a product,
+// newest first
+func GetProductPriceHistoryController(c echo.Context) error {
+	var product Product
+	if err := DB.Unscoped().First(&product, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Product not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve product")
+	}
+
+	var history []ProductPriceHistory
+	if err := DB.Where("product_id = ?", product.ID).Order("id DESC").Find(&history).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve price history")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Price history retrieved successfully",
+		Data:    history,
+	})
+}
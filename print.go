@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// PrintBillRequest selects the printer a receipt is sent to
+type PrintBillRequest struct {
+	PrinterID string `json:"printer_id"`
+}
+
+// validatePrinterSettings fills in default print settings and rejects
+// unsupported paper widths and code pages
+func validatePrinterSettings(printer *Printer) string {
+	if printer.PaperWidth == 0 {
+		printer.PaperWidth = 80
+	}
+	if printer.CodePage == "" {
+		printer.CodePage = "PC437"
+	}
+	if printer.PaperWidth != 58 && printer.PaperWidth != 80 {
+		return "PaperWidth must be 58 or 80"
+	}
+	if _, ok := escposCodePages[printer.CodePage]; !ok {
+		return "Unsupported code page " + printer.CodePage
+	}
+	return ""
+}
+
+// PrintTestPageController sends a test page to a printer
+func PrintTestPageController(c echo.Context) error {
+	var printer Printer
+	if err := DB.First(&printer, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Printer not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find printer")
+	}
+
+	data, err := RenderTestPage(printer)
+	if err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Failed to render test page: "+err.Error())
+	}
+	if err := SendToPrinter(printer.Address, data); err != nil {
+		return createErrorResponse(c, http.StatusBadGateway, "Failed to send to printer: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Test page printed successfully",
+		Data:    nil,
+	})
+}
+
+// PrintBillController prints the customer receipt of a table
+func PrintBillController(c echo.Context) error {
+	tableNumber, err := strconv.Atoi(c.Param("table_number"))
+	if err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid table number")
+	}
+
+	var request PrintBillRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+
+	var printer Printer
+	if err := DB.First(&printer, "id = ?", request.PrinterID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Printer not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find printer")
+	}
+
+	bill, err := buildBill(DB, tableNumber)
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to calculate bill")
+	}
+
+	data, err := RenderReceipt(printer, bill)
+	if err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Failed to render receipt: "+err.Error())
+	}
+	if err := SendToPrinter(printer.Address, data); err != nil {
+		return createErrorResponse(c, http.StatusBadGateway, "Failed to send to printer: "+err.Error())
+	}
+
+	// Handing over the printed bill moves the orders to billed
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := transitionOrders(tx, billOrderIDs(bill), OrderStatusBilled, "Bill printed"); err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "table_session", bill.SessionID, nil, map[string]interface{}{
+			"bill_printed": billOrderIDs(bill),
+			"grand_total":  bill.TotalAmount,
+		})
+		return nil
+	}); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update order status")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Receipt printed successfully",
+		Data:    bill,
+	})
+}
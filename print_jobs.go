@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Print job statuses
+const (
+	PrintJobPending  = "pending"
+	PrintJobPrinting = "printing"
+	PrintJobDone     = "done"
+	PrintJobDead     = "dead"
+)
+
+// Print job kinds
+const (
+	PrintKindKitchen = "kitchen"
+	PrintKindVoid    = "void"
+)
+
+const (
+	printWorkerInterval  = 2 * time.Second
+	printWorkerBatchSize = 20
+	printRetryBaseDelay  = 5 * time.Second
+	printRetryMaxDelay   = 5 * time.Minute
+	defaultPrintAttempts = 5
+)
+
+// PrintJob is a rendered ESC/POS document waiting to be sent to a printer.
+// Jobs are written in the same transaction as the order so a ticket is never
+// lost when the printer is offline; the print worker retries them with
+// backoff until they succeed or run out of attempts.
+type PrintJob struct {
+	gorm.Model
+	OrderID         uint      `gorm:"not null;index"`
+	PrinterID       string    `gorm:"size:1;not null;index"`
+	KitchenTicketID *uint     `gorm:"index"`
+	Kind            string    `gorm:"size:20;not null"`
+	Payload         []byte    `gorm:"type:mediumblob;not null" json:"-"`
+	Status          string    `gorm:"size:20;not null;default:pending;index"`
+	Attempts        int       `gorm:"not null;default:0"`
+	MaxAttempts     int       `gorm:"not null"`
+	NextAttemptAt   time.Time `gorm:"index"`
+	LastError       string    `gorm:"size:255"`
+	PrintedAt       *time.Time
+}
+
+// printMaxAttempts reads PRINT_MAX_ATTEMPTS, falling back to the default
+func printMaxAttempts() int {
+	if value, err := strconv.Atoi(os.Getenv("PRINT_MAX_ATTEMPTS")); err == nil && value > 0 {
+		return value
+	}
+	return defaultPrintAttempts
+}
+
+// printRetryDelay doubles the wait after every failed attempt
+func printRetryDelay(attempts int) time.Duration {
+	delay := printRetryBaseDelay
+	for i := 1; i < attempts && delay < printRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > printRetryMaxDelay {
+		delay = printRetryMaxDelay
+	}
+	return delay
+}
+
+// enqueueTicketPrintJobs renders the given kitchen tickets and queues them
+// for their printers. Stations without a network address are display-only
+// and get no print job. Voided items are left off, and tickets with nothing
+// left to make are not printed.
+func enqueueTicketPrintJobs(tx *gorm.DB, ticketIDs []uint) error {
+	if len(ticketIDs) == 0 {
+		return nil
+	}
+
+	var tickets []KitchenTicket
+	if err := tx.Preload("Order").Preload("Items", liveTicketItems).Preload("Items.OrderItem.Product").Preload("Items.OrderItem.Variant").Preload("Items.OrderItem.Modifiers").
+		Where("id IN ?", ticketIDs).Find(&tickets).Error; err != nil {
+		return err
+	}
+
+	for _, ticket := range tickets {
+		var printer Printer
+		if err := tx.First(&printer, "id = ?", ticket.PrinterID).Error; err != nil {
+			return err
+		}
+		if printer.Address == "" || len(ticket.Items) == 0 {
+			continue
+		}
+
+		items := make([]OrderItem, 0, len(ticket.Items))
+		for _, ticketItem := range ticket.Items {
+			items = append(items, ticketItem.OrderItem)
+		}
+		payload, err := RenderKitchenTicket(printer, ticket.Order, items)
+		if err != nil {
+			return err
+		}
+
+		ticketID := ticket.ID
+		if err := enqueuePrintJob(tx, ticket.OrderID, printer.ID, &ticketID, PrintKindKitchen, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueuePrintJob queues a rendered document for a printer
+func enqueuePrintJob(tx *gorm.DB, orderID uint, printerID string, ticketID *uint, kind string, payload []byte) error {
+	job := PrintJob{
+		OrderID:         orderID,
+		PrinterID:       printerID,
+		KitchenTicketID: ticketID,
+		Kind:            kind,
+		Payload:         payload,
+		Status:          PrintJobPending,
+		MaxAttempts:     printMaxAttempts(),
+		NextAttemptAt:   time.Now(),
+	}
+	return tx.Create(&job).Error
+}
+
+// StartPrintWorker sends queued print jobs in the background
+func StartPrintWorker() {
+	// Jobs left in printing by a previous run never finished
+	DB.Model(&PrintJob{}).Where("status = ?", PrintJobPrinting).
+		Update("status", PrintJobPending)
+
+	go func() {
+		ticker := time.NewTicker(printWorkerInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			processPrintJobs()
+		}
+	}()
+}
+
+// processPrintJobs sends every print job that is due
+func processPrintJobs() {
+	var jobs []PrintJob
+	if err := DB.Where("status = ? AND next_attempt_at <= ?", PrintJobPending, time.Now()).
+		Order("next_attempt_at").Limit(printWorkerBatchSize).Find(&jobs).Error; err != nil {
+		log.Printf("Failed to load print jobs: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		// Claim the job so another worker does not print it twice
+		claim := DB.Model(&PrintJob{}).
+			Where("id = ? AND status = ?", job.ID, PrintJobPending).
+			Update("status", PrintJobPrinting)
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue
+		}
+		sendPrintJob(job)
+	}
+}
+
+// sendPrintJob sends one job and records the outcome
+func sendPrintJob(job PrintJob) {
+	var printer Printer
+	err := DB.First(&printer, "id = ?", job.PrinterID).Error
+	if err == nil {
+		err = SendToPrinter(printer.Address, job.Payload)
+	}
+
+	job.Attempts++
+	if err == nil {
+		now := time.Now()
+		job.Status = PrintJobDone
+		job.PrintedAt = &now
+		job.LastError = ""
+	} else {
+		job.LastError = truncate(err.Error(), 255)
+		if job.Attempts >= job.MaxAttempts {
+			job.Status = PrintJobDead
+			log.Printf("Print job %d for printer %s is dead after %d attempts: %v", job.ID, job.PrinterID, job.Attempts, err)
+		} else {
+			job.Status = PrintJobPending
+			job.NextAttemptAt = time.Now().Add(printRetryDelay(job.Attempts))
+			log.Printf("Print job %d for printer %s failed, retrying: %v", job.ID, job.PrinterID, err)
+		}
+	}
+
+	if err := DB.Model(&job).Select("Attempts", "Status", "PrintedAt", "LastError", "NextAttemptAt").
+		Updates(&job).Error; err != nil {
+		log.Printf("Failed to update print job %d: %v", job.ID, err)
+	}
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	return value[:length]
+}
+
+// GetFailedPrintJobsController lists dead print jobs, or jobs of another
+// status with ?status=
+func GetFailedPrintJobsController(c echo.Context) error {
+	status := c.QueryParam("status")
+	if status == "" {
+		status = PrintJobDead
+	}
+
+	query := DB.Where("status = ?", status).Order("created_at desc")
+	if printerID := c.QueryParam("printer_id"); printerID != "" {
+		query = query.Where("printer_id = ?", printerID)
+	}
+
+	var jobs []PrintJob
+	if err := query.Find(&jobs).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve print jobs")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Print jobs retrieved successfully",
+		Data:    jobs,
+	})
+}
+
+// RetryPrintJobController puts a dead print job back in the queue
+func RetryPrintJobController(c echo.Context) error {
+	id := c.Param("id")
+
+	var job PrintJob
+	if err := DB.First(&job, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Print job not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find print job")
+	}
+
+	if job.Status != PrintJobDead {
+		return createErrorResponse(c, http.StatusConflict, "Only dead print jobs can be retried")
+	}
+
+	before := auditSnapshot(job)
+	job.Status = PrintJobPending
+	job.Attempts = 0
+	job.NextAttemptAt = time.Now()
+	if err := DB.Model(&job).Select("Status", "Attempts", "NextAttemptAt").Updates(&job).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retry print job")
+	}
+	recordAudit(c, DB, AuditUpdate, "print_job", job.ID, before, job)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Print job queued again",
+		Data:    job,
+	})
+}
+
+// ReprintOrderController queues every kitchen ticket of an order again
+func ReprintOrderController(c echo.Context) error {
+	id := c.Param("id")
+
+	var order Order
+	if err := DB.First(&order, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Order not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve order")
+	}
+
+	var ticketIDs []uint
+	if err := DB.Model(&KitchenTicket{}).Where("order_id = ?", order.ID).Pluck("id", &ticketIDs).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve kitchen tickets")
+	}
+	if len(ticketIDs) == 0 {
+		return createErrorResponse(c, http.StatusNotFound, "Order has no kitchen tickets")
+	}
+
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := enqueueTicketPrintJobs(tx, ticketIDs); err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditCreate, "reprint", order.ID, nil, map[string]interface{}{"kitchen_ticket_ids": ticketIDs})
+		return nil
+	}); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to queue reprint")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Order tickets queued for reprint",
+		Data:    nil,
+	})
+}
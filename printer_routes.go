@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// PrinterRoute sends order items to a printer. A route matches either a
+// category or a single product; routes marked Fallback catch every
+// item no other route matches. Several routes may point the same item at
+// different printers.
+type PrinterRoute struct {
+	gorm.Model
+	CategoryID *uint     `gorm:"index" json:"category_id"`
+	ProductID  *uint     `gorm:"index" json:"product_id"`
+	Fallback   bool      `gorm:"not null;default:false" json:"fallback"`
+	PrinterID  string    `gorm:"size:1;not null" json:"printer_id"`
+	Printer    Printer   `gorm:"foreignKey:PrinterID;references:ID" json:"printer"`
+	Category   *Category `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+}
+
+// printerRouter resolves the printers an order item is sent to
+type printerRouter struct {
+	byProduct  map[uint][]string
+	byCategory map[uint][]string
+	fallback   []string
+}
+
+// loadPrinterRouter reads every printer route into memory
+func loadPrinterRouter(db *gorm.DB) (*printerRouter, error) {
+	var routes []PrinterRoute
+	if err := db.Order("id").Find(&routes).Error; err != nil {
+		return nil, err
+	}
+
+	router := &printerRouter{
+		byProduct:  make(map[uint][]string),
+		byCategory: make(map[uint][]string),
+	}
+	for _, route := range routes {
+		switch {
+		case route.ProductID != nil:
+			router.byProduct[*route.ProductID] = appendUnique(router.byProduct[*route.ProductID], route.PrinterID)
+		case route.CategoryID != nil:
+			router.byCategory[*route.CategoryID] = appendUnique(router.byCategory[*route.CategoryID], route.PrinterID)
+		case route.Fallback:
+			router.fallback = appendUnique(router.fallback, route.PrinterID)
+		}
+	}
+	return router, nil
+}
+
+// resolve returns the printer IDs for a product. Product routes win over
+// category routes, and the fallback printers are used when neither matches.
+func (r *printerRouter) resolve(product Product) ([]string, bool) {
+	if ids, ok := r.byProduct[product.ID]; ok {
+		return ids, false
+	}
+	if product.CategoryID != nil {
+		if ids, ok := r.byCategory[*product.CategoryID]; ok {
+			return ids, false
+		}
+	}
+	return r.fallback, true
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// seedPrinterRoutes keeps the old hard-coded routing on an empty routing table
+func seedPrinterRoutes() {
+	var count int64
+	if err := DB.Model(&PrinterRoute{}).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+
+	defaults := map[string]string{
+		"Minuman": "Printer Bar",
+		"Makanan": "Printer Dapur",
+	}
+	for categoryNama, printerName := range defaults {
+		var printer Printer
+		if err := DB.Where("name = ?", printerName).First(&printer).Error; err != nil {
+			log.Printf("Skipping default route for %s: %v", categoryNama, err)
+			continue
+		}
+		category, err := findOrCreateCategory(DB, categoryNama)
+		if err != nil {
+			log.Printf("Skipping default route for %s: %v", categoryNama, err)
+			continue
+		}
+		DB.Create(&PrinterRoute{CategoryID: &category.ID, PrinterID: printer.ID})
+	}
+}
+
+// validatePrinterRoute checks that a route matches exactly one kind of item
+// and points at an existing printer
+func validatePrinterRoute(route PrinterRoute) string {
+	matchers := 0
+	if route.CategoryID != nil {
+		matchers++
+	}
+	if route.ProductID != nil {
+		matchers++
+	}
+	if route.Fallback {
+		matchers++
+	}
+	if matchers != 1 {
+		return "Route needs exactly one of category_id, product_id or fallback"
+	}
+	if route.PrinterID == "" {
+		return "printer_id is required"
+	}
+
+	var printer Printer
+	if err := DB.First(&printer, "id = ?", route.PrinterID).Error; err != nil {
+		return "Printer not found"
+	}
+	if route.CategoryID != nil {
+		var category Category
+		if err := DB.First(&category, *route.CategoryID).Error; err != nil {
+			return "Category not found"
+		}
+	}
+	if route.ProductID != nil {
+		var product Product
+		if err := DB.First(&product, *route.ProductID).Error; err != nil {
+			return "Product not found"
+		}
+	}
+	return ""
+}
+
+// CreatePrinterRouteController adds a routing rule
+func CreatePrinterRouteController(c echo.Context) error {
+	var route PrinterRoute
+	if err := c.Bind(&route); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	if message := validatePrinterRoute(route); message != "" {
+		return createErrorResponse(c, http.StatusBadRequest, message)
+	}
+
+	if err := DB.Create(&route).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to create printer route")
+	}
+	recordAudit(c, DB, AuditCreate, "printer_route", route.ID, nil, route)
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Printer route created successfully",
+		Data:    route,
+	})
+}
+
+// GetPrinterRoutesController lists routing rules, optionally for one printer
+func GetPrinterRoutesController(c echo.Context) error {
+	query := DB.Preload("Printer").Preload("Category").Order("id")
+	if printerID := c.QueryParam("printer_id"); printerID != "" {
+		query = query.Where("printer_id = ?", printerID)
+	}
+
+	var routes []PrinterRoute
+	if err := query.Find(&routes).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve printer routes")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Printer routes retrieved successfully",
+		Data:    routes,
+	})
+}
+
+// UpdatePrinterRouteController replaces a routing rule
+func UpdatePrinterRouteController(c echo.Context) error {
+	id := c.Param("id")
+
+	var updatedRoute PrinterRoute
+	if err := c.Bind(&updatedRoute); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	var route PrinterRoute
+	if err := DB.First(&route, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Printer route not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find printer route")
+	}
+
+	if message := validatePrinterRoute(updatedRoute); message != "" {
+		return createErrorResponse(c, http.StatusBadRequest, message)
+	}
+
+	before := auditSnapshot(route)
+	route.CategoryID = updatedRoute.CategoryID
+	route.ProductID = updatedRoute.ProductID
+	route.Fallback = updatedRoute.Fallback
+	route.PrinterID = updatedRoute.PrinterID
+
+	if err := DB.Save(&route).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update printer route")
+	}
+	recordAudit(c, DB, AuditUpdate, "printer_route", route.ID, before, route)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Printer route updated successfully",
+		Data:    route,
+	})
+}
+
+// DeletePrinterRouteController removes a routing rule
+func DeletePrinterRouteController(c echo.Context) error {
+	id := c.Param("id")
+
+	var route PrinterRoute
+	if err := DB.First(&route, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Printer route not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to find printer route")
+	}
+
+	if err := DB.Delete(&route).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to delete printer route")
+	}
+	recordAudit(c, DB, AuditSoftDelete, "printer_route", route.ID, route, nil)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Printer route deleted successfully",
+		Data:    nil,
+	})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ProductMixLine is what one product, category or Varian sold
+type ProductMixLine struct {
+	ID       uint            `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Category string          `json:"category,omitempty"`
+	Varian   string          `json:"varian,omitempty"`
+	Quantity int             `json:"quantity"`
+	Revenue  decimal.Decimal `json:"revenue"`
+	Share    decimal.Decimal `json:"share"` // percentage of the revenue of the range
+}
+
+// HourlySales is what was sold in one hour of the day over the range
+type HourlySales struct {
+	Hour     int             `json:"hour"`
+	Orders   int             `json:"orders"`
+	Quantity int             `json:"quantity"`
+	Revenue  decimal.Decimal `json:"revenue"`
+}
+
+// ProductMixReport breaks down item sales over a date range. Revenue is
+// before bill discounts, service charge and tax.
+type ProductMixReport struct {
+	From          string           `json:"from"`
+	To            string           `json:"to"`
+	Quantity      int              `json:"quantity"`
+	Revenue       decimal.Decimal  `json:"revenue"`
+	Products      []ProductMixLine `json:"products"`
+	Categories    []ProductMixLine `json:"categories"`
+	Varians       []ProductMixLine `json:"varians"`
+	TopSellers    []ProductMixLine `json:"top_sellers"`
+	BottomSellers []ProductMixLine `json:"bottom_sellers"`
+	Hourly        []HourlySales    `json:"hourly"`
+}
+
+// unsoldOrderStatuses are orders whose items never counted as sold
+var unsoldOrderStatuses = []string{OrderStatusCancelled, OrderStatusPendingApproval}
+
+// sortMix orders lines by revenue, then quantity, then name
+func sortMix(lines []ProductMixLine) {
+	sort.Slice(lines, func(i, j int) bool {
+		if !lines[i].Revenue.Equal(lines[j].Revenue) {
+			return lines[i].Revenue.GreaterThan(lines[j].Revenue)
+		}
+		if lines[i].Quantity != lines[j].Quantity {
+			return lines[i].Quantity > lines[j].Quantity
+		}
+		return lines[i].Name < lines[j].Name
+	})
+}
+
+// mixShares fills in the revenue share of each line
+func mixShares(lines []ProductMixLine, total decimal.Decimal) {
+	if !total.IsPositive() {
+		return
+	}
+	for i := range lines {
+		lines[i].Share = lines[i].Revenue.Mul(hundred).Div(total).Round(2)
+	}
+}
+
+// GetProductMixController reports quantity sold and revenue per product,
+// category and Varian between from and to (YYYY-MM-DD, inclusive, today by
+// default), with the top and bottom sellers and sales per hour. Varian is
+// the variant picked on the item, or the product's own Varian. Products on
+// the menu that sold nothing are listed too, so they show up as bottom
+// sellers. limit sets the number of top and bottom sellers (default 5).
+func GetProductMixController(c echo.Context) error {
+	from, _, err := parseDay(c.QueryParam("from"))
+	if err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "from must be a date like 2024-01-31")
+	}
+	to := c.QueryParam("to")
+	if to == "" {
+		to = from.Format(dayLayout)
+	}
+	toDay, end, err := parseDay(to)
+	if err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "to must be a date like 2024-01-31")
+	}
+	if toDay.Before(from) {
+		return createErrorResponse(c, http.StatusBadRequest, "to cannot be before from")
+	}
+	if end.Sub(from).Hours() > 24*366 {
+		return createErrorResponse(c, http.StatusBadRequest, "The range cannot be longer than a year")
+	}
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 {
+		limit = 5
+	}
+
+	unscoped := func(db *gorm.DB) *gorm.DB { return db.Unscoped() }
+	var orders []Order
+	if err := DB.Preload("Items", "voided_at IS NULL AND quantity > 0").
+		Preload("Items.Product", unscoped).Preload("Items.Product.Category", unscoped).
+		Preload("Items.Variant", unscoped).Preload("Items.Modifiers").
+		Where("created_at >= ? AND created_at < ? AND status NOT IN ?", from, end, unsoldOrderStatuses).
+		Order("created_at, id").Find(&orders).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve sales")
+	}
+
+	report := ProductMixReport{
+		From:   from.Format(dayLayout),
+		To:     toDay.Format(dayLayout),
+		Hourly: make([]HourlySales, 24),
+	}
+	for hour := range report.Hourly {
+		report.Hourly[hour].Hour = hour
+	}
+
+	products := make(map[uint]*ProductMixLine)
+	categories := make(map[string]*ProductMixLine)
+	varians := make(map[string]*ProductMixLine)
+	addLine := func(lines map[string]*ProductMixLine, key string, line ProductMixLine, quantity int, revenue decimal.Decimal) {
+		if lines[key] == nil {
+			lines[key] = &line
+		}
+		lines[key].Quantity += quantity
+		lines[key].Revenue = lines[key].Revenue.Add(revenue)
+	}
+
+	for _, order := range orders {
+		hourly := &report.Hourly[order.CreatedAt.In(time.Local).Hour()]
+		if len(order.Items) > 0 {
+			hourly.Orders++
+		}
+		for _, item := range order.Items {
+			revenue := itemLineTotal(item)
+			report.Quantity += item.Quantity
+			report.Revenue = report.Revenue.Add(revenue)
+			hourly.Quantity += item.Quantity
+			hourly.Revenue = hourly.Revenue.Add(revenue)
+
+			// Named as ordered; a product renamed within the range takes
+			// its latest name
+			product := itemProduct(item)
+			line := products[item.ProductID]
+			if line == nil {
+				line = &ProductMixLine{ID: item.ProductID, Category: categoryName(item.Product)}
+				products[item.ProductID] = line
+			}
+			line.Name, line.Varian = product.Name, product.Varian
+			line.Quantity += item.Quantity
+			line.Revenue = line.Revenue.Add(revenue)
+
+			category := ProductMixLine{Name: categoryName(item.Product)}
+			if item.Product.CategoryID != nil {
+				category.ID = *item.Product.CategoryID
+			}
+			addLine(categories, category.Name, category, item.Quantity, revenue)
+			// The variant the guest picked, or the product's own Varian
+			varian := item.VariantName
+			if varian == "" && item.ProductName == "" && item.Variant != nil {
+				varian = item.Variant.Name
+			}
+			if varian == "" {
+				varian = product.Varian
+			}
+			addLine(varians, varian, ProductMixLine{Name: varian}, item.Quantity, revenue)
+		}
+	}
+
+	// Products on the menu that sold nothing
+	var menu []Product
+	if err := DB.Preload("Category").Find(&menu).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve products")
+	}
+	for _, product := range menu {
+		if products[product.ID] == nil {
+			products[product.ID] = &ProductMixLine{ID: product.ID, Name: product.Name, Category: categoryName(product), Varian: product.Varian}
+		}
+	}
+
+	report.Products = make([]ProductMixLine, 0, len(products))
+	for _, line := range products {
+		report.Products = append(report.Products, *line)
+	}
+	report.Categories = make([]ProductMixLine, 0, len(categories))
+	for _, line := range categories {
+		report.Categories = append(report.Categories, *line)
+	}
+	report.Varians = make([]ProductMixLine, 0, len(varians))
+	for _, line := range varians {
+		report.Varians = append(report.Varians, *line)
+	}
+	for _, lines := range [][]ProductMixLine{report.Products, report.Categories, report.Varians} {
+		sortMix(lines)
+		mixShares(lines, report.Revenue)
+	}
+
+	// Top sellers come first; bottom sellers are only taken from the
+	// products left over, so the two lists never overlap
+	top := limit
+	if top > len(report.Products) {
+		top = len(report.Products)
+	}
+	bottom := limit
+	if bottom > len(report.Products)-top {
+		bottom = len(report.Products) - top
+	}
+	report.TopSellers = report.Products[:top]
+	report.BottomSellers = make([]ProductMixLine, 0, bottom)
+	for i := len(report.Products) - 1; i >= len(report.Products)-bottom; i-- {
+		report.BottomSellers = append(report.BottomSellers, report.Products[i])
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Product mix retrieved successfully",
+		Data:    report,
+	})
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ProductVariant is a size or style of a product, e.g. "Large" for Es Teh,
+// priced as the product price plus PriceDelta
+type ProductVariant struct {
+	gorm.Model
+	ProductID  uint            `gorm:"not null;index" json:"product_id"`
+	Name       string          `gorm:"size:100;not null" json:"name"`
+	PriceDelta decimal.Decimal `gorm:"not null;type:decimal(10,2);default:0" json:"price_delta"`
+	SortOrder  int             `gorm:"not null;default:0" json:"sort_order"`
+}
+
+// ModifierGroup is a set of choices offered with products, e.g. sugar level.
+// Guests pick between MinSelect and MaxSelect modifiers; a required group
+// needs at least one. MaxSelect 0 means no upper limit.
+type ModifierGroup struct {
+	gorm.Model
+	Name      string     `gorm:"size:100;not null;uniqueIndex" json:"name"`
+	Required  bool       `gorm:"not null;default:false" json:"required"`
+	MinSelect int        `gorm:"not null;default:0" json:"min_select"`
+	MaxSelect int        `gorm:"not null;default:0" json:"max_select"`
+	Modifiers []Modifier `gorm:"foreignKey:ModifierGroupID" json:"modifiers"`
+}
+
+// Modifier is a single choice in a modifier group
+type Modifier struct {
+	gorm.Model
+	ModifierGroupID uint            `gorm:"not null;index" json:"modifier_group_id"`
+	Name            string          `gorm:"size:100;not null" json:"name"`
+	PriceDelta      decimal.Decimal `gorm:"not null;type:decimal(10,2);default:0" json:"price_delta"`
+}
+
+// OrderItemModifier is a modifier chosen for an order item. Name and
+// PriceDelta are copied so later menu edits do not change the order.
+type OrderItemModifier struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	OrderItemID uint            `gorm:"not null;index" json:"order_item_id"`
+	ModifierID  uint            `gorm:"not null" json:"modifier_id"`
+	Name        string          `gorm:"size:100;not null" json:"name"`
+	PriceDelta  decimal.Decimal `gorm:"not null;type:decimal(10,2)" json:"price_delta"`
+}
+
+// SetProductModifierGroupsRequest replaces the modifier groups of a product
+type SetProductModifierGroupsRequest struct {
+	ModifierGroupIDs []uint `json:"modifier_group_ids"`
+}
+
+// resolveItemOptions checks the variant and modifiers chosen for a product
+// and returns them ready to be stored with the order item
+func resolveItemOptions(tx *gorm.DB, product Product, request OrderItemRequest) (*ProductVariant, []OrderItemModifier, error) {
+	var variants []ProductVariant
+	if err := tx.Where("product_id = ?", product.ID).Find(&variants).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var variant *ProductVariant
+	if request.VariantID != nil {
+		for i := range variants {
+			if variants[i].ID == *request.VariantID {
+				variant = &variants[i]
+			}
+		}
+		if variant == nil {
+			return nil, nil, fmt.Errorf("Variant %d is not available for %s", *request.VariantID, product.Name)
+		}
+	} else if len(variants) > 0 {
+		return nil, nil, fmt.Errorf("Choose a variant for %s", product.Name)
+	}
+
+	var groups []ModifierGroup
+	if err := tx.Model(&product).Preload("Modifiers").Association("ModifierGroups").Find(&groups); err != nil {
+		return nil, nil, err
+	}
+
+	chosen := make(map[uint]bool)
+	for _, modifierID := range request.ModifierIDs {
+		if chosen[modifierID] {
+			return nil, nil, fmt.Errorf("Modifier %d is chosen twice", modifierID)
+		}
+		chosen[modifierID] = true
+	}
+
+	var modifiers []OrderItemModifier
+	for _, group := range groups {
+		count := 0
+		for _, modifier := range group.Modifiers {
+			if !chosen[modifier.ID] {
+				continue
+			}
+			count++
+			delete(chosen, modifier.ID)
+			modifiers = append(modifiers, OrderItemModifier{
+				ModifierID: modifier.ID,
+				Name:       modifier.Name,
+				PriceDelta: modifier.PriceDelta,
+			})
+		}
+
+		min := group.MinSelect
+		if group.Required && min < 1 {
+			min = 1
+		}
+		if count < min {
+			return nil, nil, fmt.Errorf("Choose at least %d from %s for %s", min, group.Name, product.Name)
+		}
+		if group.MaxSelect > 0 && count > group.MaxSelect {
+			return nil, nil, fmt.Errorf("Choose at most %d from %s for %s", group.MaxSelect, group.Name, product.Name)
+		}
+	}
+	for modifierID := range chosen {
+		return nil, nil, fmt.Errorf("Modifier %d is not available for %s", modifierID, product.Name)
+	}
+
+	return variant, modifiers, nil
+}
+
+// validateModifierGroup checks the selection limits of a modifier group
+func validateModifierGroup(group ModifierGroup) string {
+	if strings.TrimSpace(group.Name) == "" {
+		return "Name is required"
+	}
+	if group.MinSelect < 0 || group.MaxSelect < 0 {
+		return "Selection limits cannot be negative"
+	}
+	if group.MaxSelect > 0 && group.MinSelect > group.MaxSelect {
+		return "min_select cannot be more than max_select"
+	}
+	if group.MaxSelect > 0 && group.MaxSelect > len(group.Modifiers) {
+		return "max_select cannot be more than the number of modifiers"
+	}
+	for _, modifier := range group.Modifiers {
+		if strings.TrimSpace(modifier.Name) == "" {
+			return "Every modifier needs a name"
+		}
+	}
+	return ""
+}
+
+// CreateProductVariantController adds a variant to a product
+func CreateProductVariantController(c echo.Context) error {
+	var product Product
+	if err := DB.First(&product, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Product not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve product")
+	}
+
+	var variant ProductVariant
+	if err := c.Bind(&variant); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+	if strings.TrimSpace(variant.Name) == "" {
+		return createErrorResponse(c, http.StatusBadRequest, "Name is required")
+	}
+	if product.Price.Add(variant.PriceDelta).IsNegative() {
+		return createErrorResponse(c, http.StatusBadRequest, "Variant price cannot be negative")
+	}
+
+	variant.ID = 0
+	variant.ProductID = product.ID
+	if err := DB.Create(&variant).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to create variant")
+	}
+	recordAudit(c, DB, AuditCreate, "product_variant", variant.ID, nil, variant)
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Variant created successfully",
+		Data:    variant,
+	})
+}
+
+// GetProductVariantsController lists the variants of a product
+func GetProductVariantsController(c echo.Context) error {
+	var variants []ProductVariant
+	if err := DB.Where("product_id = ?", c.Param("id")).Order("sort_order, id").Find(&variants).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve variants")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Variants retrieved successfully",
+		Data:    variants,
+	})
+}
+
+// UpdateProductVariantController updates a variant
+func UpdateProductVariantController(c echo.Context) error {
+	var variant ProductVariant
+	if err := DB.First(&variant, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Variant not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve variant")
+	}
+
+	var updatedVariant ProductVariant
+	if err := c.Bind(&updatedVariant); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+	if strings.TrimSpace(updatedVariant.Name) == "" {
+		return createErrorResponse(c, http.StatusBadRequest, "Name is required")
+	}
+
+	before := auditSnapshot(variant)
+	variant.Name = updatedVariant.Name
+	variant.PriceDelta = updatedVariant.PriceDelta
+	variant.SortOrder = updatedVariant.SortOrder
+	if err := DB.Save(&variant).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update variant")
+	}
+	recordAudit(c, DB, AuditUpdate, "product_variant", variant.ID, before, variant)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Variant updated successfully",
+		Data:    variant,
+	})
+}
+
+// DeleteProductVariantController soft-deletes a variant
+func DeleteProductVariantController(c echo.Context) error {
+	var variant ProductVariant
+	if err := DB.First(&variant, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Variant not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve variant")
+	}
+
+	if err := DB.Delete(&variant).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to delete variant")
+	}
+	recordAudit(c, DB, AuditSoftDelete, "product_variant", variant.ID, variant, nil)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Variant deleted successfully",
+		Data:    nil,
+	})
+}
+
+// CreateModifierGroupController creates a modifier group with its modifiers
+func CreateModifierGroupController(c echo.Context) error {
+	var group ModifierGroup
+	if err := c.Bind(&group); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+	if message := validateModifierGroup(group); message != "" {
+		return createErrorResponse(c, http.StatusBadRequest, message)
+	}
+
+	var existingGroup ModifierGroup
+	if err := DB.Where("name = ?", group.Name).First(&existingGroup).Error; err == nil {
+		return createErrorResponse(c, http.StatusConflict, "Modifier group with name "+group.Name+" already exists")
+	}
+
+	if err := DB.Create(&group).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to create modifier group")
+	}
+	recordAudit(c, DB, AuditCreate, "modifier_group", group.ID, nil, group)
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Modifier group created successfully",
+		Data:    group,
+	})
+}
+
+// GetModifierGroupsController lists modifier groups with their modifiers
+func GetModifierGroupsController(c echo.Context) error {
+	var groups []ModifierGroup
+	if err := DB.Preload("Modifiers").Order("name").Find(&groups).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve modifier groups")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Modifier groups retrieved successfully",
+		Data:    groups,
+	})
+}
+
+// UpdateModifierGroupController updates a modifier group and replaces its
+// modifiers. Modifiers sent with an ID are updated, the others are created,
+// and modifiers left out are deleted.
+func UpdateModifierGroupController(c echo.Context) error {
+	var group ModifierGroup
+	if err := DB.Preload("Modifiers").First(&group, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Modifier group not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve modifier group")
+	}
+
+	var updatedGroup ModifierGroup
+	if err := c.Bind(&updatedGroup); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+	if message := validateModifierGroup(updatedGroup); message != "" {
+		return createErrorResponse(c, http.StatusBadRequest, message)
+	}
+
+	before := auditSnapshot(group)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		group.Name = updatedGroup.Name
+		group.Required = updatedGroup.Required
+		group.MinSelect = updatedGroup.MinSelect
+		group.MaxSelect = updatedGroup.MaxSelect
+		if err := tx.Omit("Modifiers").Save(&group).Error; err != nil {
+			return err
+		}
+
+		keep := make([]uint, 0, len(updatedGroup.Modifiers))
+		for i := range updatedGroup.Modifiers {
+			modifier := &updatedGroup.Modifiers[i]
+			modifier.ModifierGroupID = group.ID
+			if modifier.ID != 0 {
+				if err := tx.Model(&Modifier{}).Where("id = ? AND modifier_group_id = ?", modifier.ID, group.ID).
+					Updates(map[string]interface{}{"name": modifier.Name, "price_delta": modifier.PriceDelta}).Error; err != nil {
+					return err
+				}
+			} else if err := tx.Create(modifier).Error; err != nil {
+				return err
+			}
+			keep = append(keep, modifier.ID)
+		}
+
+		remove := tx.Where("modifier_group_id = ?", group.ID)
+		if len(keep) > 0 {
+			remove = remove.Where("id NOT IN ?", keep)
+		}
+		if err := remove.Delete(&Modifier{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Preload("Modifiers").First(&group, group.ID).Error; err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "modifier_group", group.ID, before, group)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update modifier group")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Modifier group updated successfully",
+		Data:    group,
+	})
+}
+
+// DeleteModifierGroupController soft-deletes a modifier group and detaches
+// it from every product
+func DeleteModifierGroupController(c echo.Context) error {
+	var group ModifierGroup
+	if err := DB.First(&group, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Modifier group not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve modifier group")
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM product_modifier_groups WHERE modifier_group_id = ?", group.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&group).Error; err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditSoftDelete, "modifier_group", group.ID, group, nil)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to delete modifier group")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Modifier group deleted successfully",
+		Data:    nil,
+	})
+}
+
+// SetProductModifierGroupsController replaces the modifier groups offered
+// with a product
+func SetProductModifierGroupsController(c echo.Context) error {
+	var product Product
+	if err := DB.First(&product, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Product not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve product")
+	}
+
+	var request SetProductModifierGroupsRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	ids := uniqueIDs(request.ModifierGroupIDs)
+	var groups []ModifierGroup
+	if len(ids) > 0 {
+		if err := DB.Where("id IN ?", ids).Find(&groups).Error; err != nil {
+			return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve modifier groups")
+		}
+		if len(groups) != len(ids) {
+			return createErrorResponse(c, http.StatusBadRequest, "Unknown modifier group")
+		}
+	}
+
+	var before []uint
+	if err := DB.Table("product_modifier_groups").Where("product_id = ?", product.ID).Pluck("modifier_group_id", &before).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve product modifier groups")
+	}
+	if err := DB.Model(&product).Association("ModifierGroups").Replace(groups); err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update product modifier groups")
+	}
+	product.ModifierGroups = groups
+	recordAudit(c, DB, AuditUpdate, "product_modifier_groups", product.ID, before, ids)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Product modifier groups updated successfully",
+		Data:    product,
+	})
+}
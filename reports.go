@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const dayLayout = "2006-01-02"
+
+// BusinessDay is a day closed with a Z report. Its report is frozen at
+// closing and orders placed on it can no longer be changed.
+type BusinessDay struct {
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	Date       string          `gorm:"size:10;not null;uniqueIndex" json:"date"` // YYYY-MM-DD
+	ClosedAt   time.Time       `json:"closed_at"`
+	ClosedByID *uint           `json:"closed_by_id"`
+	ClosedBy   string          `gorm:"size:100" json:"closed_by"`
+	Report     json.RawMessage `gorm:"type:json" json:"report,omitempty"`
+}
+
+// BillSettlement freezes the breakdown of a bill when it is settled, so
+// later promo or tax changes do not alter the reports of past days
+type BillSettlement struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	SessionID     uint            `gorm:"index" json:"session_id"`
+	TableNumber   int             `gorm:"not null" json:"table_number"`
+	OrderCount    int             `gorm:"not null" json:"order_count"`
+	Subtotal      decimal.Decimal `gorm:"not null;type:decimal(12,2)" json:"subtotal"`
+	Discount      decimal.Decimal `gorm:"not null;type:decimal(12,2)" json:"discount"`
+	ServiceCharge decimal.Decimal `gorm:"not null;type:decimal(12,2)" json:"service_charge"`
+	Tax           decimal.Decimal `gorm:"not null;type:decimal(12,2)" json:"tax"`
+	Rounding      decimal.Decimal `gorm:"not null;type:decimal(12,2)" json:"rounding"`
+	GrandTotal    decimal.Decimal `gorm:"not null;type:decimal(12,2)" json:"grand_total"`
+	SettledAt     time.Time       `gorm:"not null;index" json:"settled_at"`
+}
+
+// DailyReport sums up the bills settled on a day. Bills count on the day
+// they were settled, with the breakdown frozen at that moment; payments and
+// voids on the day they were made.
+type DailyReport struct {
+	Date            string               `json:"date"`
+	Closed          bool                 `json:"closed"` // frozen by a Z report
+	GrossSales      decimal.Decimal      `json:"gross_sales"`
+	Discounts       decimal.Decimal      `json:"discounts"`
+	ServiceCharge   decimal.Decimal      `json:"service_charge"`
+	Tax             decimal.Decimal      `json:"tax"`
+	Rounding        decimal.Decimal      `json:"rounding"`
+	NetSales        decimal.Decimal      `json:"net_sales"`       // after discounts, without service, tax and rounding
+	TotalCollected  decimal.Decimal      `json:"total_collected"` // payments taken on the day
+	BillCount       int                  `json:"bill_count"`
+	OrderCount      int                  `json:"order_count"`
+	AverageTicket   decimal.Decimal      `json:"average_ticket"` // net sales per order
+	CancelledOrders int                  `json:"cancelled_orders"`
+	Payments        []PaymentMethodTotal `json:"payments"`
+	Voids           VoidSummary          `json:"voids"`
+}
+
+// PaymentMethodTotal is what was taken with one payment method
+type PaymentMethodTotal struct {
+	Method string          `json:"method"`
+	Count  int             `json:"count"`
+	Amount decimal.Decimal `json:"amount"`
+}
+
+// VoidSummary is what was voided off orders
+type VoidSummary struct {
+	Count    int             `json:"count"`
+	Quantity int             `json:"quantity"`
+	Amount   decimal.Decimal `json:"amount"`
+}
+
+// ZCloseRequest selects the day to close, today by default
+type ZCloseRequest struct {
+	Date string `json:"date"`
+}
+
+var errDayClosed = errors.New("business day is closed")
+
+// parseDay reads a YYYY-MM-DD date in local time, defaulting to today, and
+// returns the start of that day and the next
+func parseDay(value string) (time.Time, time.Time, error) {
+	if value == "" {
+		value = time.Now().Format(dayLayout)
+	}
+	start, err := time.ParseInLocation(dayLayout, value, time.Local)
+	if err != nil {
+		return start, start, err
+	}
+	return start, start.AddDate(0, 0, 1), nil
+}
+
+// closedDayMessage returns a message when the day an order was placed on
+// has been closed with a Z report, so the order must not change any more
+func closedDayMessage(db *gorm.DB, order Order) (string, error) {
+	date := order.CreatedAt.In(time.Local).Format(dayLayout)
+	var count int64
+	if err := db.Model(&BusinessDay{}).Where("date = ?", date).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "Business day " + date + " is closed; its orders can no longer be changed", nil
+	}
+	return "", nil
+}
+
+// recordSettlement stores the breakdown of a bill that has just been
+// settled. Bills without orders are not recorded.
+func recordSettlement(tx *gorm.DB, bill Bill, settledAt time.Time) error {
+	if len(bill.Orders) == 0 {
+		return nil
+	}
+	breakdown := bill.Breakdown
+	return tx.Create(&BillSettlement{
+		SessionID:     bill.SessionID,
+		TableNumber:   bill.TableNumber,
+		OrderCount:    len(bill.Orders),
+		Subtotal:      breakdown.Subtotal,
+		Discount:      breakdown.Discount,
+		ServiceCharge: breakdown.ServiceCharge,
+		Tax:           breakdown.Tax,
+		Rounding:      breakdown.Rounding,
+		GrandTotal:    breakdown.GrandTotal,
+		SettledAt:     settledAt,
+	}).Error
+}
+
+// backfillBillSettlements records a settlement for every table session
+// closed before settlements were stored. Their bills are worked out once
+// with the promos and tax setting of today, then frozen.
+func backfillBillSettlements() {
+	var sessions []TableSession
+	if err := DB.Where("status = ? AND closed_at IS NOT NULL", SessionClosed).Find(&sessions).Error; err != nil {
+		log.Printf("Failed to load table sessions to backfill: %v", err)
+		return
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, session := range sessions {
+			bill, err := buildSessionBill(tx, session, []string{OrderStatusCancelled})
+			if err != nil {
+				return err
+			}
+			if err := recordSettlement(tx, bill, *session.ClosedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to backfill bill settlements: %v", err)
+	}
+}
+
+// buildDailyReport works out the sales report of the day starting at start
+func buildDailyReport(db *gorm.DB, start, end time.Time) (DailyReport, error) {
+	report := DailyReport{
+		Date:     start.Format(dayLayout),
+		Payments: []PaymentMethodTotal{},
+	}
+
+	var settlements []BillSettlement
+	if err := db.Where("settled_at >= ? AND settled_at < ?", start, end).Find(&settlements).Error; err != nil {
+		return report, err
+	}
+	for _, settlement := range settlements {
+		report.GrossSales = report.GrossSales.Add(settlement.Subtotal)
+		report.Discounts = report.Discounts.Add(settlement.Discount)
+		report.ServiceCharge = report.ServiceCharge.Add(settlement.ServiceCharge)
+		report.Tax = report.Tax.Add(settlement.Tax)
+		report.Rounding = report.Rounding.Add(settlement.Rounding)
+		report.BillCount++
+		report.OrderCount += settlement.OrderCount
+	}
+	report.NetSales = report.GrossSales.Sub(report.Discounts)
+	if report.OrderCount > 0 {
+		report.AverageTicket = roundMoney(report.NetSales.Div(decimal.NewFromInt(int64(report.OrderCount))))
+	}
+
+	var cancelled int64
+	if err := db.Model(&Order{}).Where("status = ? AND created_at >= ? AND created_at < ?", OrderStatusCancelled, start, end).
+		Count(&cancelled).Error; err != nil {
+		return report, err
+	}
+	report.CancelledOrders = int(cancelled)
+
+	if err := db.Model(&Payment{}).Select("method, COUNT(*) AS count, SUM(amount) AS amount").
+		Where("created_at >= ? AND created_at < ?", start, end).
+		Group("method").Order("method").Scan(&report.Payments).Error; err != nil {
+		return report, err
+	}
+	for _, payment := range report.Payments {
+		report.TotalCollected = report.TotalCollected.Add(payment.Amount)
+	}
+
+	var voids []OrderItemVoid
+	if err := db.Where("created_at >= ? AND created_at < ?", start, end).Find(&voids).Error; err != nil {
+		return report, err
+	}
+	if len(voids) > 0 {
+		itemIDs := make([]uint, 0, len(voids))
+		for _, void := range voids {
+			itemIDs = append(itemIDs, void.OrderItemID)
+		}
+		var items []OrderItem
+		if err := db.Preload("Product", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).Preload("Variant").Preload("Modifiers").
+			Where("id IN ?", uniqueIDs(itemIDs)).Find(&items).Error; err != nil {
+			return report, err
+		}
+		byID := make(map[uint]OrderItem, len(items))
+		for _, item := range items {
+			byID[item.ID] = item
+		}
+		for _, void := range voids {
+			report.Voids.Count++
+			report.Voids.Quantity += void.Quantity
+			report.Voids.Amount = report.Voids.Amount.Add(itemUnitPrice(byID[void.OrderItemID]).Mul(decimal.NewFromInt(int64(void.Quantity))))
+		}
+	}
+
+	return report, nil
+}
+
+// GetDailyReportController returns the sales report of a day, today by
+// default. Closed days return the report frozen at their Z close.
+func GetDailyReportController(c echo.Context) error {
+	start, end, err := parseDay(c.QueryParam("date"))
+	if err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "date must be a date like 2024-01-31")
+	}
+
+	var day BusinessDay
+	err = DB.Where("date = ?", start.Format(dayLayout)).First(&day).Error
+	if err == nil {
+		var report DailyReport
+		if err := json.Unmarshal(day.Report, &report); err != nil {
+			return createErrorResponse(c, http.StatusInternalServerError, "Failed to read the Z report")
+		}
+		return c.JSON(http.StatusOK, BaseResponse{
+			Status:  true,
+			Message: "Daily report retrieved successfully",
+			Data:    report,
+		})
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve business day")
+	}
+
+	report, err := buildDailyReport(DB, start, end)
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to build daily report")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Daily report retrieved successfully",
+		Data:    report,
+	})
+}
+
+// ZCloseController closes a business day: its report is frozen and the
+// orders placed on it can no longer be changed. Every table seated on or
+// before that day must be closed first.
+func ZCloseController(c echo.Context) error {
+	var request ZCloseRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	start, end, err := parseDay(request.Date)
+	if err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "date must be a date like 2024-01-31")
+	}
+	if start.After(time.Now()) {
+		return createErrorResponse(c, http.StatusBadRequest, "A day cannot be closed before it starts")
+	}
+
+	var (
+		day     BusinessDay
+		status  = http.StatusInternalServerError
+		message = "Failed to close business day"
+	)
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&BusinessDay{}).Where("date = ?", start.Format(dayLayout)).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			status, message = http.StatusConflict, "Business day "+start.Format(dayLayout)+" is already closed"
+			return errDayClosed
+		}
+
+		var open []int
+		if err := tx.Model(&TableSession{}).Where("status = ? AND opened_at < ?", SessionOpen, end).
+			Order("table_number").Pluck("table_number", &open).Error; err != nil {
+			return err
+		}
+		if len(open) > 0 {
+			status, message = http.StatusConflict, fmt.Sprintf("Tables %v are still open; close them before the Z report", open)
+			return errDayClosed
+		}
+
+		report, err := buildDailyReport(tx, start, end)
+		if err != nil {
+			return err
+		}
+		report.Closed = true
+
+		day = BusinessDay{
+			Date:     report.Date,
+			ClosedAt: time.Now(),
+			Report:   auditSnapshot(report),
+		}
+		if staff, ok := currentStaff(c); ok {
+			day.ClosedByID = &staff.ID
+			day.ClosedBy = staff.Username
+		}
+		if err := tx.Create(&day).Error; err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditCreate, "business_day", day.ID, nil, day)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Business day " + day.Date + " closed",
+		Data:    day,
+	})
+}
+
+// GetBusinessDaysController lists the closed business days, newest first
+func GetBusinessDaysController(c echo.Context) error {
+	var days []BusinessDay
+	if err := DB.Omit("report").Order("date DESC").Limit(366).Find(&days).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve business days")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Business days retrieved successfully",
+		Data:    days,
+	})
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reservation statuses
+const (
+	ReservationBooked    = "booked"
+	ReservationSeated    = "seated"
+	ReservationCancelled = "cancelled"
+	ReservationNoShow    = "no_show"
+)
+
+// Waitlist statuses
+const (
+	WaitlistWaiting = "waiting"
+	WaitlistSeated  = "seated"
+	WaitlistLeft    = "left"
+)
+
+// defaultTurnMinutes is how long a party keeps a table when
+// RESERVATION_TURN_MINUTES is not set
+const defaultTurnMinutes = 90
+
+// Reservation is a booked table for a party at a given time
+type Reservation struct {
+	gorm.Model
+	GuestName  string     `gorm:"size:100;not null" json:"guest_name"`
+	Phone      string     `gorm:"size:30" json:"phone"`
+	PartySize  int        `gorm:"not null" json:"party_size"`
+	ReservedAt time.Time  `gorm:"not null;index" json:"reserved_at"`
+	MejaID     *uint      `gorm:"index" json:"meja_id"`
+	Status     string     `gorm:"size:20;not null;default:booked;index" json:"status"`
+	Note       string     `gorm:"size:255" json:"note"`
+	SessionID  *uint      `json:"session_id"` // the table session opened when the party was seated
+	SeatedAt   *time.Time `json:"seated_at"`
+}
+
+// WaitlistEntry is a walk-in party waiting for a table. QuotedWait is the
+// wait in minutes the party was told when joining.
+type WaitlistEntry struct {
+	gorm.Model
+	GuestName  string     `gorm:"size:100;not null" json:"guest_name"`
+	Phone      string     `gorm:"size:30" json:"phone"`
+	PartySize  int        `gorm:"not null" json:"party_size"`
+	Status     string     `gorm:"size:20;not null;default:waiting;index" json:"status"`
+	QuotedWait int        `gorm:"not null;default:0" json:"quoted_wait"`
+	MejaID     *uint      `json:"meja_id"`
+	SessionID  *uint      `json:"session_id"`
+	SeatedAt   *time.Time `json:"seated_at"`
+}
+
+// SeatRequest seats a party, at TableNumber or else at the reserved table
+type SeatRequest struct {
+	TableNumber int `json:"table_number"`
+}
+
+// errReservationRejected aborts a reservation transaction after the
+// response message has been chosen
+var errReservationRejected = errors.New("reservation rejected")
+
+// turnTime is how long a party is expected to keep a table
+func turnTime() time.Duration {
+	if value, err := strconv.Atoi(os.Getenv("RESERVATION_TURN_MINUTES")); err == nil && value > 0 {
+		return time.Duration(value) * time.Minute
+	}
+	return defaultTurnMinutes * time.Minute
+}
+
+// validateReservation checks the party details and that the reserved table
+// exists, is large enough and is not booked within one turn of the time.
+// It returns a message when the reservation is rejected.
+func validateReservation(tx *gorm.DB, reservation Reservation) (string, error) {
+	if strings.TrimSpace(reservation.GuestName) == "" {
+		return "guest_name is required", nil
+	}
+	if reservation.PartySize <= 0 {
+		return "party_size must be greater than zero", nil
+	}
+	if reservation.ReservedAt.IsZero() {
+		return "reserved_at is required", nil
+	}
+	if reservation.MejaID == nil {
+		return "", nil
+	}
+
+	meja, err := resolveMeja(tx, int(*reservation.MejaID), "")
+	if errors.Is(err, errUnknownTable) {
+		return err.Error(), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if meja.Capacity > 0 && reservation.PartySize > meja.Capacity {
+		return fmt.Sprintf("%s seats %d, party is %d", meja.Nama, meja.Capacity, reservation.PartySize), nil
+	}
+
+	// Lock the table's bookings so two hosts cannot book the same slot
+	turn := turnTime()
+	var clash Reservation
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("meja_id = ? AND id <> ? AND status IN ? AND reserved_at > ? AND reserved_at < ?",
+			meja.ID, reservation.ID, []string{ReservationBooked, ReservationSeated},
+			reservation.ReservedAt.Add(-turn), reservation.ReservedAt.Add(turn)).
+		First(&clash).Error
+	if err == nil {
+		return fmt.Sprintf("%s is already booked at %s for %s", meja.Nama, clash.ReservedAt.Format("02/01/2006 15:04"), clash.GuestName), nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+	return "", nil
+}
+
+// seatParty opens a session for a party at a free table
+func seatParty(tx *gorm.DB, tableNumber, partySize int) (TableSession, string, error) {
+	meja, err := resolveMeja(tx, tableNumber, "")
+	if errors.Is(err, errUnknownTable) {
+		return TableSession{}, err.Error(), nil
+	}
+	if err != nil {
+		return TableSession{}, "", err
+	}
+
+	if _, err := activeSession(tx, int(meja.ID)); err == nil {
+		return TableSession{}, meja.Nama + " is occupied", nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return TableSession{}, "", err
+	}
+
+	session, err := openSession(tx, int(meja.ID), partySize)
+	return session, "", err
+}
+
+// quoteWait estimates the minutes until a table fits a new party, assuming
+// occupied tables turn after the turn time and the parties already waiting
+// take the first tables to come free
+func quoteWait(db *gorm.DB, partySize int) (int, error) {
+	var mejas []Meja
+	if err := db.Where("capacity = 0 OR capacity >= ?", partySize).Find(&mejas).Error; err != nil {
+		return 0, err
+	}
+	if len(mejas) == 0 {
+		return 0, nil
+	}
+
+	var sessions []TableSession
+	if err := db.Where("status = ?", SessionOpen).Find(&sessions).Error; err != nil {
+		return 0, err
+	}
+	openedAt := make(map[int]time.Time, len(sessions))
+	for _, session := range sessions {
+		openedAt[session.TableNumber] = session.OpenedAt
+	}
+
+	now := time.Now()
+	turn := turnTime()
+	freeIn := make([]time.Duration, 0, len(mejas))
+	for _, meja := range mejas {
+		wait := time.Duration(0)
+		if opened, ok := openedAt[int(meja.ID)]; ok {
+			wait = opened.Add(turn).Sub(now)
+			if wait < 0 {
+				wait = 0
+			}
+		} else if meja.Status == MejaOccupied {
+			wait = turn
+		}
+		freeIn = append(freeIn, wait)
+	}
+	sort.Slice(freeIn, func(i, j int) bool { return freeIn[i] < freeIn[j] })
+
+	var ahead int64
+	if err := db.Model(&WaitlistEntry{}).Where("status = ? AND party_size <= ?", WaitlistWaiting, partySize).
+		Count(&ahead).Error; err != nil {
+		return 0, err
+	}
+
+	// Each round of tables coming free seats len(freeIn) parties
+	rounds := time.Duration(int(ahead) / len(freeIn))
+	wait := freeIn[int(ahead)%len(freeIn)] + rounds*turn
+	return int((wait + time.Minute - 1) / time.Minute), nil
+}
+
+// CreateReservationController books a table
+func CreateReservationController(c echo.Context) error {
+	var reservation Reservation
+	if err := c.Bind(&reservation); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	reservation.ID = 0
+	reservation.Status = ReservationBooked
+	reservation.SessionID = nil
+	reservation.SeatedAt = nil
+
+	var (
+		status  = http.StatusInternalServerError
+		message = "Failed to create reservation"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		rejection, err := validateReservation(tx, reservation)
+		if err != nil {
+			return err
+		}
+		if rejection != "" {
+			status, message = http.StatusConflict, rejection
+			return errReservationRejected
+		}
+		if err := tx.Create(&reservation).Error; err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditCreate, "reservation", reservation.ID, nil, reservation)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Reservation created successfully",
+		Data:    reservation,
+	})
+}
+
+// GetReservationsController lists the reservations of a day, today by
+// default, optionally filtered by status
+func GetReservationsController(c echo.Context) error {
+	day := time.Now()
+	if date := c.QueryParam("date"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			return createErrorResponse(c, http.StatusBadRequest, "date must be YYYY-MM-DD")
+		}
+		day = parsed
+	}
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+
+	query := DB.Where("reserved_at >= ? AND reserved_at < ?", start, start.AddDate(0, 0, 1)).Order("reserved_at")
+	if status := c.QueryParam("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var reservations []Reservation
+	if err := query.Find(&reservations).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve reservations")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Reservations retrieved successfully",
+		Data:    reservations,
+	})
+}
+
+// UpdateReservationController changes the details of a booked reservation
+func UpdateReservationController(c echo.Context) error {
+	var updatedReservation Reservation
+	if err := c.Bind(&updatedReservation); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+
+	var (
+		reservation Reservation
+		status      = http.StatusInternalServerError
+		message     = "Failed to update reservation"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&reservation, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "Reservation not found"
+			}
+			return err
+		}
+		if reservation.Status != ReservationBooked {
+			status, message = http.StatusConflict, "Only booked reservations can be changed"
+			return errReservationRejected
+		}
+
+		before := auditSnapshot(reservation)
+		reservation.GuestName = updatedReservation.GuestName
+		reservation.Phone = updatedReservation.Phone
+		reservation.PartySize = updatedReservation.PartySize
+		reservation.ReservedAt = updatedReservation.ReservedAt
+		reservation.MejaID = updatedReservation.MejaID
+		reservation.Note = updatedReservation.Note
+
+		rejection, err := validateReservation(tx, reservation)
+		if err != nil {
+			return err
+		}
+		if rejection != "" {
+			status, message = http.StatusConflict, rejection
+			return errReservationRejected
+		}
+		if err := tx.Save(&reservation).Error; err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "reservation", reservation.ID, before, reservation)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Reservation updated successfully",
+		Data:    reservation,
+	})
+}
+
+// CancelReservationController cancels a booked reservation
+func CancelReservationController(c echo.Context) error {
+	return closeReservation(c, ReservationCancelled, "Reservation cancelled")
+}
+
+// NoShowReservationController records that a party never arrived
+func NoShowReservationController(c echo.Context) error {
+	return closeReservation(c, ReservationNoShow, "Reservation marked as no-show")
+}
+
+// closeReservation ends a booked reservation without seating it, freeing
+// its slot for other bookings
+func closeReservation(c echo.Context, to, done string) error {
+	var reservation Reservation
+	if err := DB.First(&reservation, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Reservation not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve reservation")
+	}
+
+	before := auditSnapshot(reservation)
+	result := DB.Model(&reservation).Where("status = ?", ReservationBooked).Update("status", to)
+	if result.Error != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update reservation")
+	}
+	if result.RowsAffected == 0 {
+		return createErrorResponse(c, http.StatusConflict, "Reservation is already "+reservation.Status)
+	}
+	reservation.Status = to
+	recordAudit(c, DB, AuditUpdate, "reservation", reservation.ID, before, reservation)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: done,
+		Data:    reservation,
+	})
+}
+
+// SeatReservationController seats an arrived party and opens their table
+// for ordering
+func SeatReservationController(c echo.Context) error {
+	var request SeatRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+
+	var (
+		reservation Reservation
+		session     TableSession
+		status      = http.StatusInternalServerError
+		message     = "Failed to seat reservation"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&reservation, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "Reservation not found"
+			}
+			return err
+		}
+		if reservation.Status != ReservationBooked {
+			status, message = http.StatusConflict, "Reservation is already "+reservation.Status
+			return errReservationRejected
+		}
+
+		tableNumber := request.TableNumber
+		if tableNumber == 0 && reservation.MejaID != nil {
+			tableNumber = int(*reservation.MejaID)
+		}
+		if tableNumber == 0 {
+			status, message = http.StatusBadRequest, "table_number is required for a reservation without a table"
+			return errReservationRejected
+		}
+
+		var (
+			rejection string
+			err       error
+		)
+		session, rejection, err = seatParty(tx, tableNumber, reservation.PartySize)
+		if err != nil {
+			return err
+		}
+		if rejection != "" {
+			status, message = http.StatusConflict, rejection
+			return errReservationRejected
+		}
+
+		before := auditSnapshot(reservation)
+		now := time.Now()
+		mejaID := uint(tableNumber)
+		reservation.Status = ReservationSeated
+		reservation.MejaID = &mejaID
+		reservation.SessionID = &session.ID
+		reservation.SeatedAt = &now
+		if err := tx.Save(&reservation).Error; err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "reservation", reservation.ID, before, reservation)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Reservation seated successfully",
+		Data: map[string]interface{}{
+			"reservation": reservation,
+			"session":     session,
+		},
+	})
+}
+
+// JoinWaitlistController adds a walk-in party to the waitlist and quotes
+// their wait
+func JoinWaitlistController(c echo.Context) error {
+	var entry WaitlistEntry
+	if err := c.Bind(&entry); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	if strings.TrimSpace(entry.GuestName) == "" {
+		return createErrorResponse(c, http.StatusBadRequest, "guest_name is required")
+	}
+	if entry.PartySize <= 0 {
+		return createErrorResponse(c, http.StatusBadRequest, "party_size must be greater than zero")
+	}
+
+	quoted, err := quoteWait(DB, entry.PartySize)
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to quote wait time")
+	}
+
+	entry.ID = 0
+	entry.Status = WaitlistWaiting
+	entry.QuotedWait = quoted
+	entry.MejaID = nil
+	entry.SessionID = nil
+	entry.SeatedAt = nil
+	if err := DB.Create(&entry).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to join waitlist")
+	}
+	recordAudit(c, DB, AuditCreate, "waitlist_entry", entry.ID, nil, entry)
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: fmt.Sprintf("Added to waitlist, about %d minutes", quoted),
+		Data:    entry,
+	})
+}
+
+// GetWaitlistController lists the parties still waiting, longest first
+func GetWaitlistController(c echo.Context) error {
+	var entries []WaitlistEntry
+	if err := DB.Where("status = ?", WaitlistWaiting).Order("created_at").Find(&entries).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve waitlist")
+	}
+
+	now := time.Now()
+	waitlist := make([]map[string]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		waitlist = append(waitlist, map[string]interface{}{
+			"entry":          entry,
+			"waited_minutes": int(now.Sub(entry.CreatedAt) / time.Minute),
+		})
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Waitlist retrieved successfully",
+		Data:    waitlist,
+	})
+}
+
+// SeatWaitlistController seats a waiting party at a free table
+func SeatWaitlistController(c echo.Context) error {
+	var request SeatRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	if request.TableNumber == 0 {
+		return createErrorResponse(c, http.StatusBadRequest, "table_number is required")
+	}
+
+	var (
+		entry   WaitlistEntry
+		session TableSession
+		status  = http.StatusInternalServerError
+		message = "Failed to seat party"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&entry, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "Waitlist entry not found"
+			}
+			return err
+		}
+		if entry.Status != WaitlistWaiting {
+			status, message = http.StatusConflict, "Party has already "+entry.Status
+			return errReservationRejected
+		}
+
+		var (
+			rejection string
+			err       error
+		)
+		session, rejection, err = seatParty(tx, request.TableNumber, entry.PartySize)
+		if err != nil {
+			return err
+		}
+		if rejection != "" {
+			status, message = http.StatusConflict, rejection
+			return errReservationRejected
+		}
+
+		before := auditSnapshot(entry)
+		now := time.Now()
+		mejaID := uint(request.TableNumber)
+		entry.Status = WaitlistSeated
+		entry.MejaID = &mejaID
+		entry.SessionID = &session.ID
+		entry.SeatedAt = &now
+		if err := tx.Save(&entry).Error; err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "waitlist_entry", entry.ID, before, entry)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Party seated successfully",
+		Data: map[string]interface{}{
+			"entry":   entry,
+			"session": session,
+		},
+	})
+}
+
+// LeaveWaitlistController removes a party that gave up waiting
+func LeaveWaitlistController(c echo.Context) error {
+	result := DB.Model(&WaitlistEntry{}).Where("id = ? AND status = ?", c.Param("id"), WaitlistWaiting).
+		Update("status", WaitlistLeft)
+	if result.Error != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update waitlist")
+	}
+	if result.RowsAffected == 0 {
+		return createErrorResponse(c, http.StatusNotFound, "Waiting party not found")
+	}
+	recordAudit(c, DB, AuditUpdate, "waitlist_entry", c.Param("id"),
+		map[string]string{"status": WaitlistWaiting}, map[string]string{"status": WaitlistLeft})
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Party removed from waitlist",
+		Data:    nil,
+	})
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Table session statuses
+const (
+	SessionOpen   = "open"
+	SessionClosed = "closed"
+	SessionMerged = "merged" // ended by merging its table into another session
+)
+
+// TableSession is one sitting at a table, from the moment guests sit down
+// until the bill is paid and the table is freed. Orders and bills belong to
+// a session, so earlier sittings at the same table are never billed again.
+type TableSession struct {
+	gorm.Model
+	TableNumber int        `gorm:"not null;index" json:"table_number"`
+	ActiveTable *int       `gorm:"uniqueIndex" json:"-"` // the table number while open, so a table has one open session
+	Status      string     `gorm:"size:10;not null;default:open;index" json:"status"`
+	Guests      int        `gorm:"not null;default:0" json:"guests"`
+	OpenedAt    time.Time  `json:"opened_at"`
+	ClosedAt    *time.Time `json:"closed_at"`
+	Orders      []Order    `gorm:"foreignKey:SessionID" json:"orders,omitempty"`
+}
+
+// OpenSessionRequest seats guests at a table
+type OpenSessionRequest struct {
+	TableNumber int    `json:"table_number"`
+	TableCode   string `json:"table_code"`
+	Guests      int    `json:"guests"`
+}
+
+// errSessionRejected aborts a session transaction after the response
+// message has been chosen
+var errSessionRejected = errors.New("session rejected")
+
+// activeSession returns the open session of a table, which is the session
+// of another table when the two have been merged
+func activeSession(db *gorm.DB, tableNumber int) (TableSession, error) {
+	var session TableSession
+	err := db.Where("table_number = ? AND status = ?", tableNumber, SessionOpen).First(&session).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return session, err
+	}
+	err = db.Where("status = ? AND id IN (?)", SessionOpen,
+		db.Model(&TableMerge{}).Select("session_id").Where("table_number = ?", tableNumber)).
+		First(&session).Error
+	return session, err
+}
+
+// openSession starts a new session at a table
+func openSession(tx *gorm.DB, tableNumber, guests int) (TableSession, error) {
+	activeTable := tableNumber
+	session := TableSession{
+		TableNumber: tableNumber,
+		ActiveTable: &activeTable,
+		Status:      SessionOpen,
+		Guests:      guests,
+		OpenedAt:    time.Now(),
+	}
+	if err := tx.Create(&session).Error; err != nil {
+		return session, err
+	}
+	return session, setMejaStatus(tx, tableNumber, MejaOccupied)
+}
+
+// ensureSession returns the open session of a table, opening one for
+// walk-ins who order before being seated
+func ensureSession(tx *gorm.DB, tableNumber int) (TableSession, error) {
+	session, err := activeSession(tx, tableNumber)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return session, err
+	}
+	return openSession(tx, tableNumber, 0)
+}
+
+// closeSession ends a session whose bill is settled. Orders left without
+// anything to pay are marked paid, guest orders never approved are
+// cancelled and an unused split is closed. Its tables are left dirty until
+// staff mark them free after clearing them.
+func closeSession(tx *gorm.DB, session *TableSession, bill Bill) error {
+	if err := cancelPendingOrders(tx, session.ID, "Session closed"); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := recordSettlement(tx, bill, now); err != nil {
+		return err
+	}
+	if err := settleOrders(tx, billOrderIDs(bill), "Session closed"); err != nil {
+		return err
+	}
+	if err := closeOpenSplits(tx, session.ID); err != nil {
+		return err
+	}
+
+	if err := tx.Model(session).Updates(map[string]interface{}{
+		"status":       SessionClosed,
+		"closed_at":    now,
+		"active_table": nil,
+	}).Error; err != nil {
+		return err
+	}
+	session.Status = SessionClosed
+	session.ClosedAt = &now
+	session.ActiveTable = nil
+
+	merged, err := mergedTables(tx, session.ID)
+	if err != nil {
+		return err
+	}
+	for _, tableNumber := range append([]int{session.TableNumber}, merged...) {
+		if err := setMejaStatus(tx, tableNumber, MejaDirty); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTableSessions moves the unpaid orders placed before sessions
+// existed into an open session of their table
+func migrateTableSessions() {
+	var tableNumbers []int
+	if err := DB.Model(&Order{}).Distinct("table_number").
+		Where("session_id IS NULL AND status NOT IN ?", closedOrderStatuses).
+		Pluck("table_number", &tableNumbers).Error; err != nil {
+		log.Printf("Failed to migrate table sessions: %v", err)
+		return
+	}
+
+	for _, tableNumber := range tableNumbers {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			session, err := ensureSession(tx, tableNumber)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&Order{}).
+				Where("table_number = ? AND session_id IS NULL AND status NOT IN ?", tableNumber, closedOrderStatuses).
+				Update("session_id", session.ID).Error; err != nil {
+				return err
+			}
+			return tx.Model(&BillSplit{}).
+				Where("table_number = ? AND session_id IS NULL AND closed = ?", tableNumber, false).
+				Update("session_id", session.ID).Error
+		})
+		if err != nil {
+			log.Printf("Failed to migrate table %d to a session: %v", tableNumber, err)
+		}
+	}
+}
+
+// OpenSessionController seats guests at a free table
+func OpenSessionController(c echo.Context) error {
+	var request OpenSessionRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	if request.Guests < 0 {
+		return createErrorResponse(c, http.StatusBadRequest, "guests cannot be negative")
+	}
+
+	var (
+		session TableSession
+		status  = http.StatusInternalServerError
+		message = "Failed to open table session"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		meja, err := resolveMeja(tx, request.TableNumber, request.TableCode)
+		if errors.Is(err, errUnknownTable) {
+			status, message = http.StatusBadRequest, err.Error()
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		request.TableNumber = int(meja.ID)
+
+		if existing, err := activeSession(tx, request.TableNumber); err == nil {
+			status, message = http.StatusConflict, fmt.Sprintf("Table %d already has open session %d", request.TableNumber, existing.ID)
+			return errSessionRejected
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		session, err = openSession(tx, request.TableNumber, request.Guests)
+		if err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditCreate, "table_session", session.ID, nil, session)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Table session opened successfully",
+		Data:    session,
+	})
+}
+
+// GetSessionsController lists table sessions, open ones by default. Use
+// ?status=closed or ?status=all for history, optionally for one table.
+func GetSessionsController(c echo.Context) error {
+	query := DB.Order("id DESC")
+	switch status := c.QueryParam("status"); status {
+	case "", SessionOpen:
+		query = query.Where("status = ?", SessionOpen)
+	case SessionClosed:
+		query = query.Where("status = ?", SessionClosed).Limit(200)
+	case "all":
+		query = query.Limit(200)
+	default:
+		return createErrorResponse(c, http.StatusBadRequest, "status must be open, closed or all")
+	}
+	if tableNumber := c.QueryParam("table_number"); tableNumber != "" {
+		query = query.Where("table_number = ?", tableNumber)
+	}
+
+	var sessions []TableSession
+	if err := query.Find(&sessions).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve table sessions")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Table sessions retrieved successfully",
+		Data:    sessions,
+	})
+}
+
+// GetSessionBillController returns the full bill of a session, including
+// orders already paid, so closed sessions can be looked up afterwards
+func GetSessionBillController(c echo.Context) error {
+	var session TableSession
+	if err := DB.First(&session, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return createErrorResponse(c, http.StatusNotFound, "Table session not found")
+		}
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve table session")
+	}
+
+	bill, err := buildSessionBill(DB, session, []string{OrderStatusCancelled})
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to calculate bill")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Bill calculated successfully",
+		Data: map[string]interface{}{
+			"session": session,
+			"bill":    bill,
+		},
+	})
+}
+
+// CloseSessionController closes a session once its bill is paid, leaving
+// the table dirty until it is cleared
+func CloseSessionController(c echo.Context) error {
+	var (
+		session TableSession
+		status  = http.StatusInternalServerError
+		message = "Failed to close table session"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "Table session not found"
+			}
+			return err
+		}
+		if session.Status != SessionOpen {
+			status, message = http.StatusConflict, "Table session is already closed"
+			return errSessionRejected
+		}
+
+		bill, err := buildSessionBill(tx, session, closedOrderStatuses)
+		if err != nil {
+			return err
+		}
+		if bill.AmountDue.IsPositive() {
+			status, message = http.StatusConflict, "Bill still has "+formatRupiah(bill.AmountDue)+" due"
+			return errSessionRejected
+		}
+		before := auditSnapshot(session)
+		if err := closeSession(tx, &session, bill); err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "table_session", session.ID, before, session)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Table " + strconv.Itoa(session.TableNumber) + " is closed; mark it free once it is cleared",
+		Data:    session,
+	})
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Split modes
+const (
+	SplitEven = "even"
+	SplitSeat = "seat"
+	SplitItem = "item"
+)
+
+const maxSplitParts = 50
+
+// BillSplit divides the bill of a table into checks that are paid one by
+// one. Total is the bill total the split was made for; when orders change
+// after splitting, the split no longer matches the bill and must be redone.
+type BillSplit struct {
+	gorm.Model
+	TableNumber int             `gorm:"not null;index" json:"table_number"`
+	SessionID   *uint           `gorm:"index" json:"session_id"`
+	Mode        string          `gorm:"size:10;not null" json:"mode"`
+	Total       decimal.Decimal `gorm:"not null;type:decimal(10,2)" json:"total"`
+	Closed      bool            `gorm:"not null;default:false" json:"closed"`
+	Stale       bool            `gorm:"-" json:"stale"`
+	Checks      []BillCheck     `gorm:"foreignKey:BillSplitID" json:"checks"`
+}
+
+// BillCheck is one sub-check of a split bill
+type BillCheck struct {
+	gorm.Model
+	BillSplitID uint            `gorm:"not null;index" json:"bill_split_id"`
+	Number      int             `gorm:"not null" json:"number"`
+	Seat        int             `gorm:"not null;default:0" json:"seat"`
+	Amount      decimal.Decimal `gorm:"not null;type:decimal(10,2)" json:"amount"`
+	PaidAmount  decimal.Decimal `gorm:"-" json:"paid_amount"`
+	AmountDue   decimal.Decimal `gorm:"-" json:"amount_due"`
+	Items       []BillCheckItem `gorm:"foreignKey:BillCheckID" json:"items"`
+}
+
+// BillCheckItem assigns an order item to a check
+type BillCheckItem struct {
+	ID          uint `gorm:"primaryKey" json:"id"`
+	BillCheckID uint `gorm:"not null;index" json:"bill_check_id"`
+	OrderItemID uint `gorm:"not null;index" json:"order_item_id"`
+}
+
+// SplitBillRequest splits a bill evenly into Parts checks, by the seat
+// numbers on the items, or by explicit lists of order item IDs per check
+type SplitBillRequest struct {
+	Mode   string   `json:"mode"`
+	Parts  int      `json:"parts"`
+	Checks [][]uint `json:"checks"`
+}
+
+// loadBillSplit attaches the open split of a session to its bill, with the
+// amount paid and due on every check
+func loadBillSplit(db *gorm.DB, bill *Bill) error {
+	var split BillSplit
+	err := db.Preload("Checks", func(db *gorm.DB) *gorm.DB { return db.Order("number") }).
+		Preload("Checks.Items").
+		Where("session_id = ? AND closed = ?", bill.SessionID, false).
+		Order("id desc").First(&split).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	paid := make(map[uint]decimal.Decimal)
+	for _, payment := range bill.Payments {
+		if payment.BillCheckID != nil {
+			paid[*payment.BillCheckID] = paid[*payment.BillCheckID].Add(payment.Amount)
+		}
+	}
+	for i := range split.Checks {
+		check := &split.Checks[i]
+		check.PaidAmount = paid[check.ID]
+		check.AmountDue = decimal.Max(check.Amount.Sub(check.PaidAmount), decimal.Zero)
+	}
+	split.Stale = !split.Total.Equal(bill.TotalAmount)
+
+	bill.Split = &split
+	return nil
+}
+
+// SplitBillController splits the bill of a table into payable checks,
+// replacing an earlier split that has no payments yet
+func SplitBillController(c echo.Context) error {
+	tableNumber, err := strconv.Atoi(c.Param("table_number"))
+	if err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid table number")
+	}
+
+	var request SplitBillRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	request.Mode = strings.ToLower(request.Mode)
+
+	var (
+		split   BillSplit
+		status  = http.StatusInternalServerError
+		message = "Failed to split bill"
+	)
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		bill, err := buildBill(tx, tableNumber)
+		if err != nil {
+			return err
+		}
+		if len(bill.Orders) == 0 {
+			status, message = http.StatusNotFound, "Table has no open orders"
+			return errSplitRejected
+		}
+		if bill.PaidAmount.IsPositive() {
+			status, message = http.StatusConflict, "Bill already has payments and cannot be split again"
+			return errSplitRejected
+		}
+
+		var checks []BillCheck
+		switch request.Mode {
+		case SplitEven:
+			checks, message = splitEvenly(bill, request.Parts)
+		case SplitSeat:
+			checks, message = splitBySeat(bill)
+		case SplitItem:
+			checks, message = splitByItems(bill, request.Checks)
+		default:
+			message = "Split mode must be even, seat or item"
+		}
+		if message != "" {
+			status = http.StatusBadRequest
+			return errSplitRejected
+		}
+
+		// The previous split has no payments (checked above), so it can go
+		if err := tx.Model(&BillSplit{}).
+			Where("session_id = ? AND closed = ?", bill.SessionID, false).
+			Update("closed", true).Error; err != nil {
+			return err
+		}
+
+		sessionID := bill.SessionID
+		split = BillSplit{
+			TableNumber: tableNumber,
+			SessionID:   &sessionID,
+			Mode:        request.Mode,
+			Total:       bill.TotalAmount,
+			Checks:      checks,
+		}
+		if err := tx.Create(&split).Error; err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditCreate, "bill_split", split.ID, nil, split)
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	for i := range split.Checks {
+		split.Checks[i].AmountDue = split.Checks[i].Amount
+	}
+
+	return c.JSON(http.StatusCreated, BaseResponse{
+		Status:  true,
+		Message: "Bill split successfully",
+		Data:    split,
+	})
+}
+
+// CancelSplitController removes the open split of a table
+func CancelSplitController(c echo.Context) error {
+	tableNumber, err := strconv.Atoi(c.Param("table_number"))
+	if err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid table number")
+	}
+
+	bill, err := buildBill(DB, tableNumber)
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to calculate bill")
+	}
+	if bill.Split == nil {
+		return createErrorResponse(c, http.StatusNotFound, "Bill is not split")
+	}
+	for _, check := range bill.Split.Checks {
+		if check.PaidAmount.IsPositive() {
+			return createErrorResponse(c, http.StatusConflict, "Split already has payments")
+		}
+	}
+
+	before := auditSnapshot(bill.Split)
+	if err := DB.Model(bill.Split).Update("closed", true).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to cancel split")
+	}
+	recordAudit(c, DB, AuditUpdate, "bill_split", bill.Split.ID, before, bill.Split)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Split cancelled successfully",
+		Data:    nil,
+	})
+}
+
+var errSplitRejected = errors.New("split rejected")
+
+// splitEvenly divides the bill total into parts checks. Leftover cents go
+// to the first checks so the checks always add up to the bill.
+func splitEvenly(bill Bill, parts int) ([]BillCheck, string) {
+	if parts < 2 || parts > maxSplitParts {
+		return nil, "Parts must be between 2 and " + strconv.Itoa(maxSplitParts)
+	}
+
+	shares := distributeCents(toCents(bill.TotalAmount), equalWeights(parts))
+	checks := make([]BillCheck, parts)
+	for i := range checks {
+		checks[i] = BillCheck{Number: i + 1, Amount: fromCents(shares[i])}
+	}
+	return checks, ""
+}
+
+// splitBySeat makes one check per seat number on the items. Items without a
+// seat are shared and spread evenly over the seats.
+func splitBySeat(bill Bill) ([]BillCheck, string) {
+	seatItems := make(map[int][]uint)
+	seatCents := make(map[int]int64)
+	var sharedItems []uint
+	var sharedCents int64
+	for _, order := range bill.Orders {
+		for _, item := range order.Items {
+			cents := toCents(itemLineTotal(item))
+			if item.Seat <= 0 {
+				sharedItems = append(sharedItems, item.ID)
+				sharedCents += cents
+				continue
+			}
+			seatItems[item.Seat] = append(seatItems[item.Seat], item.ID)
+			seatCents[item.Seat] += cents
+		}
+	}
+	if len(seatItems) == 0 {
+		return nil, "No items have a seat number"
+	}
+
+	seats := make([]int, 0, len(seatItems))
+	for seat := range seatItems {
+		seats = append(seats, seat)
+	}
+	sort.Ints(seats)
+
+	shared := distributeCents(sharedCents, equalWeights(len(seats)))
+	weights := make([]int64, len(seats))
+	for i, seat := range seats {
+		weights[i] = seatCents[seat] + shared[i]
+	}
+	amounts := distributeCents(toCents(bill.TotalAmount), weights)
+
+	// Shared items are listed on every seat's check
+	checks := make([]BillCheck, len(seats))
+	for i, seat := range seats {
+		checks[i] = BillCheck{
+			Number: i + 1,
+			Seat:   seat,
+			Amount: fromCents(amounts[i]),
+			Items:  checkItems(append(seatItems[seat], sharedItems...)),
+		}
+	}
+	return checks, ""
+}
+
+// splitByItems makes one check per list of order item IDs. Every item on
+// the bill must be on exactly one check.
+func splitByItems(bill Bill, groups [][]uint) ([]BillCheck, string) {
+	if len(groups) < 2 || len(groups) > maxSplitParts {
+		return nil, "Item split needs between 2 and " + strconv.Itoa(maxSplitParts) + " checks"
+	}
+
+	itemCents := make(map[uint]int64)
+	for _, order := range bill.Orders {
+		for _, item := range order.Items {
+			itemCents[item.ID] = toCents(itemLineTotal(item))
+		}
+	}
+
+	assigned := make(map[uint]bool)
+	weights := make([]int64, len(groups))
+	for i, group := range groups {
+		if len(group) == 0 {
+			return nil, "Every check needs at least one item"
+		}
+		for _, itemID := range group {
+			cents, onBill := itemCents[itemID]
+			if !onBill {
+				return nil, "Item " + strconv.FormatUint(uint64(itemID), 10) + " is not on this bill"
+			}
+			if assigned[itemID] {
+				return nil, "Item " + strconv.FormatUint(uint64(itemID), 10) + " is on more than one check"
+			}
+			assigned[itemID] = true
+			weights[i] += cents
+		}
+	}
+	if len(assigned) != len(itemCents) {
+		return nil, "Every item on the bill must be assigned to a check"
+	}
+
+	amounts := distributeCents(toCents(bill.TotalAmount), weights)
+	checks := make([]BillCheck, len(groups))
+	for i, group := range groups {
+		checks[i] = BillCheck{
+			Number: i + 1,
+			Amount: fromCents(amounts[i]),
+			Items:  checkItems(group),
+		}
+	}
+	return checks, ""
+}
+
+func checkItems(orderItemIDs []uint) []BillCheckItem {
+	items := make([]BillCheckItem, 0, len(orderItemIDs))
+	for _, id := range orderItemIDs {
+		items = append(items, BillCheckItem{OrderItemID: id})
+	}
+	return items
+}
+
+func equalWeights(parts int) []int64 {
+	weights := make([]int64, parts)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return weights
+}
+
+// distributeCents splits total cents in proportion to weights using the
+// largest remainder method, so the shares always add up to total exactly
+func distributeCents(total int64, weights []int64) []int64 {
+	shares := make([]int64, len(weights))
+	var weightSum int64
+	for _, weight := range weights {
+		weightSum += weight
+	}
+	if weightSum == 0 || len(weights) == 0 {
+		if len(weights) > 0 {
+			return distributeCents(total, equalWeights(len(weights)))
+		}
+		return shares
+	}
+
+	// total * weight can overflow int64, so the division is done in decimal
+	remainders := make([]decimal.Decimal, len(weights))
+	var assigned int64
+	for i, weight := range weights {
+		quotient, remainder := decimal.NewFromInt(total).Mul(decimal.NewFromInt(weight)).
+			QuoRem(decimal.NewFromInt(weightSum), 0)
+		shares[i] = quotient.IntPart()
+		remainders[i] = remainder
+		assigned += shares[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]].GreaterThan(remainders[order[b]])
+	})
+	for i := 0; assigned < total; i++ {
+		shares[order[i%len(order)]]++
+		assigned++
+	}
+	return shares
+}
+
+// toCents converts a decimal(10,2) amount to whole cents
+func toCents(amount decimal.Decimal) int64 {
+	return amount.Shift(2).Round(0).IntPart()
+}
+
+func fromCents(cents int64) decimal.Decimal {
+	return decimal.New(cents, -2)
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TableMerge joins a table to the session of another table, so pushed
+// together tables order onto and pay one bill. Orders keep the number of
+// the table they were placed at, which is how un-merging sends them back.
+type TableMerge struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	SessionID   uint      `gorm:"not null;index" json:"session_id"`
+	TableNumber int       `gorm:"not null;index" json:"table_number"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TransferTableRequest moves open orders to another table. Without
+// OrderIDs every open order moves and the guests move with them.
+type TransferTableRequest struct {
+	FromTable int    `json:"from_table"`
+	ToTable   int    `json:"to_table"`
+	OrderIDs  []uint `json:"order_ids"`
+}
+
+// MergeTablesRequest merges tables into the session of the first one
+type MergeTablesRequest struct {
+	Tables []int `json:"tables"`
+}
+
+// UnmergeTablesRequest takes a merged table back out of its merge; naming
+// the main table of the merge un-merges every table
+type UnmergeTablesRequest struct {
+	TableNumber int `json:"table_number"`
+}
+
+// mergedTables returns the tables merged into a session
+func mergedTables(db *gorm.DB, sessionID uint) ([]int, error) {
+	var tables []int
+	err := db.Model(&TableMerge{}).Where("session_id = ?", sessionID).Order("table_number").
+		Pluck("table_number", &tables).Error
+	return tables, err
+}
+
+// lockedSession returns the open session of a table, locked for update
+func lockedSession(tx *gorm.DB, tableNumber int) (TableSession, error) {
+	session, err := activeSession(tx, tableNumber)
+	if err != nil {
+		return session, err
+	}
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, session.ID).Error
+	return session, err
+}
+
+// closeOpenSplits closes the splits of sessions whose orders have changed
+func closeOpenSplits(tx *gorm.DB, sessionIDs ...uint) error {
+	return tx.Model(&BillSplit{}).Where("session_id IN ? AND closed = ?", sessionIDs, false).
+		Update("closed", true).Error
+}
+
+// TransferTableController moves open orders from one table to another in
+// one transaction
+func TransferTableController(c echo.Context) error {
+	var request TransferTableRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+	if request.FromTable == request.ToTable {
+		return createErrorResponse(c, http.StatusBadRequest, "from_table and to_table must differ")
+	}
+
+	var (
+		bill    Bill
+		status  = http.StatusInternalServerError
+		message = "Failed to transfer table"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, tableNumber := range []int{request.FromTable, request.ToTable} {
+			if _, err := resolveMeja(tx, tableNumber, ""); err != nil {
+				if errors.Is(err, errUnknownTable) {
+					status, message = http.StatusBadRequest, err.Error()
+				}
+				return err
+			}
+		}
+
+		source, err := lockedSession(tx, request.FromTable)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status, message = http.StatusNotFound, fmt.Sprintf("Table %d has no open session", request.FromTable)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		merged, err := mergedTables(tx, source.ID)
+		if err != nil {
+			return err
+		}
+		if source.TableNumber != request.FromTable || len(merged) > 0 {
+			status, message = http.StatusConflict, fmt.Sprintf("Table %d is part of a merge; unmerge it first", request.FromTable)
+			return errSessionRejected
+		}
+
+		sourceBill, err := buildSessionBill(tx, source, closedOrderStatuses)
+		if err != nil {
+			return err
+		}
+		orderIDs := billOrderIDs(sourceBill)
+		if len(orderIDs) == 0 {
+			status, message = http.StatusConflict, fmt.Sprintf("Table %d has no open orders", request.FromTable)
+			return errSessionRejected
+		}
+
+		moving := orderIDs
+		if len(request.OrderIDs) > 0 {
+			moving = uniqueIDs(request.OrderIDs)
+			for _, id := range moving {
+				if !containsID(orderIDs, id) {
+					status, message = http.StatusBadRequest, fmt.Sprintf("Order %d is not open at table %d", id, request.FromTable)
+					return errSessionRejected
+				}
+			}
+		}
+		moveAll := len(moving) == len(orderIDs)
+		if !moveAll && sourceBill.PaidAmount.IsPositive() {
+			status, message = http.StatusConflict, "Bill already has payments; move every order or none"
+			return errSessionRejected
+		}
+
+		target, err := activeSession(tx, request.ToTable)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound) && moveAll:
+			// The guests move to a free table and take their session along
+			if err := tx.Model(&source).Updates(map[string]interface{}{
+				"table_number": request.ToTable,
+				"active_table": request.ToTable,
+			}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&Order{}).Where("session_id = ?", source.ID).
+				Update("table_number", request.ToTable).Error; err != nil {
+				return err
+			}
+			if err := setMejaStatus(tx, request.FromTable, MejaDirty); err != nil {
+				return err
+			}
+			if err := setMejaStatus(tx, request.ToTable, MejaOccupied); err != nil {
+				return err
+			}
+		case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		default:
+			if target.ID == 0 {
+				if target, err = openSession(tx, request.ToTable, 0); err != nil {
+					return err
+				}
+			}
+			if moveAll {
+				// Guest orders still waiting for approval are not on the
+				// bill but go along too, so closing the source does not
+				// cancel them
+				var pending []uint
+				if err := tx.Model(&Order{}).Where("session_id = ? AND status = ?", source.ID, OrderStatusPendingApproval).
+					Pluck("id", &pending).Error; err != nil {
+					return err
+				}
+				moving = append(moving, pending...)
+			}
+			if err := tx.Model(&Order{}).Where("id IN ?", moving).Updates(map[string]interface{}{
+				"session_id":   target.ID,
+				"table_number": request.ToTable,
+			}).Error; err != nil {
+				return err
+			}
+			if err := closeOpenSplits(tx, source.ID, target.ID); err != nil {
+				return err
+			}
+			if moveAll {
+				if err := tx.Model(&target).Update("guests", target.Guests+source.Guests).Error; err != nil {
+					return err
+				}
+				if err := closeSession(tx, &source, Bill{}); err != nil {
+					return err
+				}
+			}
+		}
+
+		if bill, err = buildBill(tx, request.ToTable); err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "table_session", bill.SessionID, nil, map[string]interface{}{"transfer": request})
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Orders transferred successfully",
+		Data:    bill,
+	})
+}
+
+// MergeTablesController merges tables into one session and bill. The first
+// table keeps its session; the open orders of the others join it.
+func MergeTablesController(c echo.Context) error {
+	var request MergeTablesRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+
+	tables := make([]int, 0, len(request.Tables))
+	for _, tableNumber := range request.Tables {
+		if !containsInt(tables, tableNumber) {
+			tables = append(tables, tableNumber)
+		}
+	}
+	if len(tables) < 2 {
+		return createErrorResponse(c, http.StatusBadRequest, "Merge needs at least two tables")
+	}
+
+	var (
+		bill    Bill
+		status  = http.StatusInternalServerError
+		message = "Failed to merge tables"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, tableNumber := range tables {
+			if _, err := resolveMeja(tx, tableNumber, ""); err != nil {
+				if errors.Is(err, errUnknownTable) {
+					status, message = http.StatusBadRequest, err.Error()
+				}
+				return err
+			}
+		}
+
+		primary, err := ensureSession(tx, tables[0])
+		if err != nil {
+			return err
+		}
+		if primary.TableNumber != tables[0] {
+			status, message = http.StatusConflict, fmt.Sprintf("Table %d is already merged into table %d", tables[0], primary.TableNumber)
+			return errSessionRejected
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&primary, primary.ID).Error; err != nil {
+			return err
+		}
+
+		guests := primary.Guests
+		for _, tableNumber := range tables[1:] {
+			session, err := lockedSession(tx, tableNumber)
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+			case err != nil:
+				return err
+			case session.ID == primary.ID:
+				continue
+			case session.TableNumber != tableNumber:
+				status, message = http.StatusConflict, fmt.Sprintf("Table %d is already merged into table %d", tableNumber, session.TableNumber)
+				return errSessionRejected
+			default:
+				merged, err := mergedTables(tx, session.ID)
+				if err != nil {
+					return err
+				}
+				if len(merged) > 0 {
+					status, message = http.StatusConflict, fmt.Sprintf("Table %d has merged tables; unmerge them first", tableNumber)
+					return errSessionRejected
+				}
+
+				// The open orders join the merged bill; the old session ends
+				if err := tx.Model(&Order{}).Where("session_id = ? AND status NOT IN ?", session.ID, closedOrderStatuses).
+					Update("session_id", primary.ID).Error; err != nil {
+					return err
+				}
+				if err := closeOpenSplits(tx, session.ID); err != nil {
+					return err
+				}
+				if err := tx.Model(&session).Updates(map[string]interface{}{
+					"status":       SessionMerged,
+					"closed_at":    time.Now(),
+					"active_table": nil,
+				}).Error; err != nil {
+					return err
+				}
+				guests += session.Guests
+			}
+
+			if err := tx.Create(&TableMerge{SessionID: primary.ID, TableNumber: tableNumber}).Error; err != nil {
+				return err
+			}
+			if err := setMejaStatus(tx, tableNumber, MejaOccupied); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&primary).Update("guests", guests).Error; err != nil {
+			return err
+		}
+		if err := closeOpenSplits(tx, primary.ID); err != nil {
+			return err
+		}
+
+		if bill, err = buildBill(tx, tables[0]); err != nil {
+			return err
+		}
+		recordAudit(c, tx, AuditUpdate, "table_session", primary.ID, nil, map[string]interface{}{"merge": tables})
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Tables merged successfully",
+		Data:    bill,
+	})
+}
+
+// UnmergeTablesController splits merged tables back into their own
+// sessions, taking along the orders placed at each table
+func UnmergeTablesController(c echo.Context) error {
+	var request UnmergeTablesRequest
+	if err := c.Bind(&request); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request payload")
+	}
+
+	var (
+		bills   []Bill
+		status  = http.StatusInternalServerError
+		message = "Failed to unmerge tables"
+	)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		session, err := lockedSession(tx, request.TableNumber)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status, message = http.StatusNotFound, fmt.Sprintf("Table %d has no open session", request.TableNumber)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		merged, err := mergedTables(tx, session.ID)
+		if err != nil {
+			return err
+		}
+		if len(merged) == 0 {
+			status, message = http.StatusConflict, fmt.Sprintf("Table %d is not merged", request.TableNumber)
+			return errSessionRejected
+		}
+		if request.TableNumber != session.TableNumber {
+			merged = []int{request.TableNumber}
+		}
+
+		bill, err := buildSessionBill(tx, session, closedOrderStatuses)
+		if err != nil {
+			return err
+		}
+		if bill.PaidAmount.IsPositive() {
+			status, message = http.StatusConflict, "Merged bill already has payments and cannot be unmerged"
+			return errSessionRejected
+		}
+
+		for _, tableNumber := range merged {
+			if err := tx.Where("session_id = ? AND table_number = ?", session.ID, tableNumber).
+				Delete(&TableMerge{}).Error; err != nil {
+				return err
+			}
+			own, err := openSession(tx, tableNumber, 0)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&Order{}).
+				Where("session_id = ? AND table_number = ? AND status NOT IN ?", session.ID, tableNumber, closedOrderStatuses).
+				Update("session_id", own.ID).Error; err != nil {
+				return err
+			}
+		}
+		if err := closeOpenSplits(tx, session.ID); err != nil {
+			return err
+		}
+
+		for _, tableNumber := range append([]int{session.TableNumber}, merged...) {
+			bill, err := buildBill(tx, tableNumber)
+			if err != nil {
+				return err
+			}
+			bills = append(bills, bill)
+		}
+		recordAudit(c, tx, AuditUpdate, "table_session", session.ID, nil, map[string]interface{}{"unmerge": merged})
+		return nil
+	})
+	if err != nil {
+		return createErrorResponse(c, status, message)
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Tables unmerged successfully",
+		Data:    bills,
+	})
+}
+
+func containsID(list []uint, value uint) bool {
+	for _, existing := range list {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, value int) bool {
+	for _, existing := range list {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Meja occupancy statuses
+const (
+	MejaFree     = "free"
+	MejaOccupied = "occupied"
+)
+
+// errUnknownTable is returned when an order or session names a table that
+// does not exist or has been deleted
+var errUnknownTable = errors.New("unknown table")
+
+// resolveMeja finds the table an order is for, by ID or else by its code.
+// Soft-deleted tables are not found.
+func resolveMeja(db *gorm.DB, tableNumber int, tableCode string) (Meja, error) {
+	var meja Meja
+	tableCode = strings.TrimSpace(tableCode)
+
+	var err error
+	switch {
+	case tableNumber > 0:
+		err = db.First(&meja, tableNumber).Error
+	case tableCode != "":
+		err = db.Where("code = ?", tableCode).First(&meja).Error
+	default:
+		return meja, fmt.Errorf("%w: table_number or table_code is required", errUnknownTable)
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if tableNumber > 0 {
+			return meja, fmt.Errorf("%w: table %d does not exist", errUnknownTable, tableNumber)
+		}
+		return meja, fmt.Errorf("%w: table %s does not exist", errUnknownTable, tableCode)
+	}
+	return meja, err
+}
+
+// setMejaStatus updates the occupancy status of a table
+func setMejaStatus(tx *gorm.DB, tableNumber int, status string) error {
+	return tx.Model(&Meja{}).Where("id = ?", tableNumber).Update("status", status).Error
+}
+
+// validateMeja checks the details of a table and that its code is not
+// already taken by another table
+func validateMeja(meja Meja, id uint) string {
+	if meja.Nama == "" {
+		return "Nama is required"
+	}
+	if meja.Capacity < 0 {
+		return "Capacity cannot be negative"
+	}
+	if message := validateMejaLayout(meja); message != "" {
+		return message
+	}
+	if meja.Code != nil {
+		code := strings.TrimSpace(*meja.Code)
+		if code == "" {
+			return "Code cannot be empty"
+		}
+		var existingMeja Meja
+		if err := DB.Unscoped().Where("code = ? AND id <> ?", code, id).First(&existingMeja).Error; err == nil {
+			return "Table with code " + code + " already exists"
+		}
+	}
+	return ""
+}
+
+// syncMejaOccupancy marks the tables with an open session as occupied and
+// the others as free
+func syncMejaOccupancy() {
+	openTables := DB.Model(&TableSession{}).Select("table_number").Where("status = ?", SessionOpen)
+	if err := DB.Model(&Meja{}).Where("id IN (?)", openTables).Update("status", MejaOccupied).Error; err != nil {
+		log.Printf("Failed to sync table occupancy: %v", err)
+		return
+	}
+	if err := DB.Model(&Meja{}).Where("id NOT IN (?) AND (status = ? OR status = '')", openTables, MejaOccupied).
+		Update("status", MejaFree).Error; err != nil {
+		log.Printf("Failed to sync table occupancy: %v", err)
+	}
+}
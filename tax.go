@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// TaxSetting holds the PPN and service charge applied to every bill. There
+// is a single row; without it bills carry no tax or service charge.
+// RoundingUnit rounds the grand total to the nearest multiple, e.g. 100 for
+// cash rounding to Rp100, and is ignored when zero.
+type TaxSetting struct {
+	ID               uint            `gorm:"primaryKey" json:"id"`
+	TaxPercent       decimal.Decimal `gorm:"not null;type:decimal(5,2);default:0" json:"tax_percent"`
+	ServicePercent   decimal.Decimal `gorm:"not null;type:decimal(5,2);default:0" json:"service_percent"`
+	PricesIncludeTax bool            `gorm:"not null;default:false" json:"prices_include_tax"`
+	RoundingUnit     decimal.Decimal `gorm:"not null;type:decimal(10,2);default:0" json:"rounding_unit"`
+}
+
+const taxSettingID = 1
+
+// loadTaxSetting returns the tax setting, or zero rates when none is saved
+func loadTaxSetting(db *gorm.DB) (TaxSetting, error) {
+	var setting TaxSetting
+	err := db.First(&setting, taxSettingID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return TaxSetting{ID: taxSettingID}, nil
+	}
+	return setting, err
+}
+
+// GetTaxSettingController returns the tax and service charge setting
+func GetTaxSettingController(c echo.Context) error {
+	setting, err := loadTaxSetting(DB)
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve tax setting")
+	}
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Tax setting retrieved successfully",
+		Data:    setting,
+	})
+}
+
+// UpdateTaxSettingController saves the tax and service charge setting
+func UpdateTaxSettingController(c echo.Context) error {
+	var setting TaxSetting
+	if err := c.Bind(&setting); err != nil {
+		return createErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+	}
+
+	if setting.TaxPercent.IsNegative() || setting.TaxPercent.GreaterThan(hundred) ||
+		setting.ServicePercent.IsNegative() || setting.ServicePercent.GreaterThan(hundred) {
+		return createErrorResponse(c, http.StatusBadRequest, "Percentages must be between 0 and 100")
+	}
+	if setting.RoundingUnit.IsNegative() {
+		return createErrorResponse(c, http.StatusBadRequest, "Rounding unit cannot be negative")
+	}
+
+	before, err := loadTaxSetting(DB)
+	if err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve tax setting")
+	}
+
+	setting.ID = taxSettingID
+	if err := DB.Save(&setting).Error; err != nil {
+		return createErrorResponse(c, http.StatusInternalServerError, "Failed to update tax setting")
+	}
+	recordAudit(c, DB, AuditUpdate, "tax_setting", setting.ID, before, setting)
+
+	return c.JSON(http.StatusOK, BaseResponse{
+		Status:  true,
+		Message: "Tax setting updated successfully",
+		Data:    setting,
+	})
+}